@@ -0,0 +1,112 @@
+// Package cli contains the subcommands of the server binary used for operational tasks
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lowtierkakish/praktiline-too/db"
+)
+
+var ErrUsage = errors.New("invalid usage")
+
+type command struct {
+	usage       string
+	description string
+	// Whether the command needs the cache besides the database
+	cache bool
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"migrate": {
+		usage:       "migrate up|down|status",
+		description: "apply, roll back the newest or list the database migrations",
+		run:         runMigrate,
+	},
+	"user": {
+		usage:       "user create|list|set-role|reset-password [flags]",
+		description: "manage user accounts, run an action with -h to list its flags",
+		cache:       true,
+		run:         runUser,
+	},
+	"sessions": {
+		usage:       "sessions purge [-email email]",
+		description: "remove expired sessions or log out every session of a user",
+		cache:       true,
+		run:         runSessions,
+	},
+	"materials": {
+		usage:       "materials gc|check [-remove]",
+		description: "purge the trash or compare uploaded files with the materials",
+		run:         runMaterials,
+	},
+}
+
+// Runs the subcommand named by the first argument. The config has to be loaded, connections are
+// opened as needed by the command
+func Run(ctx context.Context, args []string) error {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage(os.Stderr)
+		return fmt.Errorf("%w: unknown command %s", ErrUsage, args[0])
+	}
+
+	db.ConnectDB(ctx)
+	if cmd.cache {
+		db.InitializeCache(ctx)
+	}
+	defer db.Close()
+
+	err := cmd.run(ctx, args[1:])
+	if errors.Is(err, ErrUsage) {
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], cmd.usage)
+	}
+
+	return err
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "usage: %s [command]\n\nWithout a command the server is started.\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].description)
+	}
+	tw.Flush()
+}
+
+// Returns the only argument of a command that takes one of the given actions
+func action(args []string, actions ...string) (string, error) {
+	if len(args) != 1 {
+		return "", ErrUsage
+	}
+
+	for _, a := range actions {
+		if args[0] == a {
+			return a, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: unknown action %s, expected %s", ErrUsage, args[0], strings.Join(actions, ", "))
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
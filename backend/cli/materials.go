@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+)
+
+func runMaterials(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	switch args[0] {
+	case "gc":
+		return purgeTrash(ctx, args[1:])
+	case "check":
+		return checkFiles(ctx, args[1:])
+	default:
+		return fmt.Errorf("%w: unknown action %s", ErrUsage, args[0])
+	}
+}
+
+// Runs the trash purge right away instead of waiting for the background worker
+func purgeTrash(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+
+	result, err := services.PurgeTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged %d materials, %d homework and %d schedule entries from the trash\n", result.Materials, result.Homework, result.Schedule)
+	return nil
+}
+
+// Compares uploaded files with the materials, orphans are only removed with -remove
+func checkFiles(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("materials check", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "remove orphaned files older than the grace period")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+
+	result, err := services.ReconcileFiles(ctx, *remove)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range result.Orphans {
+		fmt.Printf("orphaned file %s\n", file)
+	}
+	for _, missing := range result.Missing {
+		fmt.Printf("material %d is missing file %s\n", missing.MaterialID, missing.File)
+	}
+
+	fmt.Printf("%d orphaned files (%d removed), %d missing files, %d sizes filled in\n", len(result.Orphans), result.Removed, len(result.Missing), result.SizesFilled)
+	return nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/pressly/goose/v3"
+)
+
+func runMigrate(ctx context.Context, args []string) error {
+	action, err := action(args, "up", "down", "status")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		return db.Migrate(ctx)
+	case "down":
+		return migrateDown(ctx)
+	default:
+		return migrateStatus(ctx)
+	}
+}
+
+func migrateDown(ctx context.Context) error {
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+
+	result, err := migrator.Down(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rolled back %s in %s\n", result.Source.Path, result.Duration)
+	return nil
+}
+
+func migrateStatus(ctx context.Context) error {
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "VERSION\tAPPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\n", status.Source.Version, appliedAt, status.Source.Path)
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+)
+
+func runSessions(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return ErrUsage
+	}
+
+	flags := flag.NewFlagSet("sessions purge", flag.ContinueOnError)
+	email := flags.String("email", "", "log out every session of this user instead of removing expired sessions")
+	if err := flags.Parse(args[1:]); err != nil {
+		return ErrUsage
+	}
+
+	if *email == "" {
+		count, err := services.PurgeExpiredSessions(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("removed %d expired sessions\n", count)
+		return nil
+	}
+
+	userID, err := services.UserIDByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+
+	count, err := services.DestroyUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("removed %d sessions of user %d\n", count, userID)
+	return nil
+}
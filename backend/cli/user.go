@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+func runUser(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return createUser(ctx, args[1:])
+	case "list":
+		return listUsers(ctx)
+	case "set-role":
+		return setUserRole(ctx, args[1:])
+	case "reset-password":
+		return resetPassword(ctx, args[1:])
+	default:
+		return fmt.Errorf("%w: unknown action %s", ErrUsage, args[0])
+	}
+}
+
+func createUser(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user (required)")
+	firstName := flags.String("first-name", "", "first name of the user (required)")
+	lastName := flags.String("last-name", "", "last name of the user (required)")
+	password := flags.String("password", "", "password of the user, a random one is generated and printed when empty")
+	role := flags.String("role", services.RoleStudent, "role of the user, student or admin")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+
+	*firstName = strings.TrimSpace(*firstName)
+	*lastName = strings.TrimSpace(*lastName)
+	if *firstName == "" || *lastName == "" {
+		return fmt.Errorf("%w: -first-name and -last-name are required", ErrUsage)
+	}
+
+	sanitized, err := utils.SanitazeEmail(*email)
+	if err != nil {
+		return err
+	}
+
+	if *role != services.RoleStudent && *role != services.RoleAdmin {
+		return services.ErrInvalidRole
+	}
+
+	generated, err := passwordOrGenerated(password)
+	if err != nil {
+		return err
+	}
+
+	if err := services.ValidateNewPassword(ctx, *password, sanitized, *firstName, *lastName); err != nil {
+		return err
+	}
+
+	userID, err := services.CreateUser(ctx, *firstName, *lastName, sanitized, *password)
+	if err != nil {
+		return err
+	}
+
+	if *role != services.RoleStudent {
+		if err := services.SetUserRole(ctx, userID, *role); err != nil {
+			return fmt.Errorf("user %d was created but the role could not be set: %w", userID, err)
+		}
+	}
+
+	fmt.Printf("created %s %d (%s)\n", *role, userID, sanitized)
+	if generated {
+		fmt.Printf("password: %s\n", *password)
+	}
+
+	return nil
+}
+
+func listUsers(ctx context.Context) error {
+	users, err := services.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "ID\tEMAIL\tNAME\tROLE")
+	for _, user := range users {
+		fmt.Fprintf(table, "%d\t%s\t%s %s\t%s\n", user.ID, user.Email, user.FirstName, user.LastName, user.Role)
+	}
+
+	return table.Flush()
+}
+
+func setUserRole(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("user set-role", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user (required)")
+	role := flags.String("role", "", "new role of the user, student or admin (required)")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+
+	userID, err := services.UserIDByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+
+	if err := services.SetUserRole(ctx, userID, *role); err != nil {
+		return err
+	}
+
+	fmt.Printf("user %d is now %s\n", userID, *role)
+	return nil
+}
+
+func resetPassword(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user (required)")
+	password := flags.String("password", "", "new password, a random one is generated and printed when empty")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+
+	userID, err := services.UserIDByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+
+	generated, err := passwordOrGenerated(password)
+	if err != nil {
+		return err
+	}
+
+	if err := services.ResetPassword(ctx, userID, *password); err != nil {
+		return err
+	}
+
+	fmt.Printf("password of user %d was reset, all of their sessions were logged out\n", userID)
+	if generated {
+		fmt.Printf("password: %s\n", *password)
+	}
+
+	return nil
+}
+
+// Fills an empty password with a random one and reports whether it did
+func passwordOrGenerated(password *string) (bool, error) {
+	if *password != "" {
+		return false, nil
+	}
+
+	generated, err := utils.GenerateRandomStringURLSafe(12)
+	if err != nil {
+		return false, err
+	}
+
+	*password = generated
+	return true, nil
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -12,20 +15,142 @@ import (
 
 type SessionConfig struct {
 	Duration time.Duration `env:"DURATION, default=168h"`
+	// Users without a password confirm sensitive changes by having logged in within this window
+	ReauthWindow time.Duration `env:"REAUTH_WINDOW, default=10m"`
+}
+
+type PasswordConfig struct {
+	// Argon2id memory in KiB
+	Argon2Memory      uint32 `env:"ARGON2_MEMORY, default=65536"`
+	Argon2Iterations  uint32 `env:"ARGON2_ITERATIONS, default=3"`
+	Argon2Parallelism uint8  `env:"ARGON2_PARALLELISM, default=2"`
+
+	MinLength int `env:"MIN_LENGTH, default=8"`
+	// Minimum strength of new passwords: 0 - weak, 1 - medium, 2 - strong
+	MinScore int `env:"MIN_SCORE, default=1"`
+	// Directory with the offline breached passwords dataset, the check is skipped when empty
+	BreachedDir string `env:"BREACHED_DIR"`
+}
+
+type LoginLimitConfig struct {
+	Window          time.Duration `env:"WINDOW, default=15m"`
+	MaxPerIP        int           `env:"MAX_PER_IP, default=50"`
+	MaxFailures     int           `env:"MAX_FAILURES, default=10"`
+	DelayAfter      int           `env:"DELAY_AFTER, default=3"`
+	BaseDelay       time.Duration `env:"BASE_DELAY, default=1s"`
+	MaxDelay        time.Duration `env:"MAX_DELAY, default=30s"`
+	LockoutDuration time.Duration `env:"LOCKOUT_DURATION, default=15m"`
+}
+
+type TrashConfig struct {
+	// How long deleted items can be restored before they are permanently removed
+	Retention     time.Duration `env:"RETENTION, default=720h"`
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL, default=1h"`
+}
+
+type UploadConfig struct {
+	// Largest file accepted by resumable uploads
+	MaxBytes int64 `env:"MAX_BYTES, default=1073741824"`
+	// Largest chunk accepted by a single PATCH request
+	ChunkBytes int64 `env:"CHUNK_BYTES, default=8388608"`
+	// Unfinished uploads are discarded after this long without a new chunk
+	Expiry time.Duration `env:"EXPIRY, default=24h"`
+}
+
+type QuotaConfig struct {
+	// Bytes each user can upload, 0 means unlimited
+	UserBytes int64 `env:"USER_BYTES, default=104857600"`
+	// Bytes all users can upload together. A deployment serves a single class, so this is the class quota
+	ClassBytes int64 `env:"CLASS_BYTES, default=2147483648"`
+}
+
+type ReconcileConfig struct {
+	// How often uploaded files are compared with the materials, 0 disables the background check
+	Interval time.Duration `env:"INTERVAL, default=24h"`
+	// Files younger than this are never orphans, their upload may still be in progress
+	GracePeriod   time.Duration `env:"GRACE_PERIOD, default=1h"`
+	RemoveOrphans bool          `env:"REMOVE_ORPHANS, default=true"`
+}
+
+type ScanConfig struct {
+	// Address of a ClamAV daemon (host:port). When empty uploads are not scanned and are visible
+	// right away, files uploaded while scanning was enabled stay pending until it is enabled again
+	ClamdAddr string        `env:"CLAMD_ADDR"`
+	Timeout   time.Duration `env:"TIMEOUT, default=5m"`
+	// StreamMaxLength configured in clamd.conf, in bytes. The default is the clamd default. While
+	// scanning is enabled uploads are limited to it, larger files couldn't be scanned
+	StreamMaxLength int64 `env:"STREAM_MAX_LENGTH, default=26214400"`
+	// Files that were too large for the daemon stay pending and are tried again after this long,
+	// so that they are scanned once StreamMaxLength was raised
+	RetryDelay time.Duration `env:"RETRY_DELAY, default=1h"`
+	// How often pending files are looked for, new uploads are scanned right after they are finished
+	Interval time.Duration `env:"INTERVAL, default=1m"`
+}
+
+type AccessLogConfig struct {
+	// Fraction of successful requests that are logged, failed requests are always logged
+	SampleRate float64 `env:"SAMPLE_RATE, default=1"`
+	// Paths that are never logged
+	ExcludePaths []string `env:"EXCLUDE_PATHS, default=/healthz,/livez,/readyz,/metrics"`
+}
+
+type ServerConfig struct {
+	// Time to read the whole request including the body, uploads have to fit in it
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT, default=60s"`
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT, default=10s"`
+	// Time to write the response, file downloads and the data export are not limited by it
+	WriteTimeout time.Duration `env:"WRITE_TIMEOUT, default=120s"`
+	IdleTimeout  time.Duration `env:"IDLE_TIMEOUT, default=120s"`
+	// How long in-flight requests and background workers get to finish after SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT, default=30s"`
+}
+
+type OIDCProviderConfig struct {
+	Issuer       string   `env:"ISSUER, required"`
+	ClientID     string   `env:"CLIENT_ID, required"`
+	ClientSecret string   `env:"CLIENT_SECRET"`
+	Scopes       []string `env:"SCOPES, default=openid,email,profile"`
+}
+
+type OIDCConfig struct {
+	// Names of the enabled providers, each one is configured with OIDC_<NAME>_* variables
+	Providers []string `env:"PROVIDERS"`
+	// Public URL of this API, used to build the redirect URL registered at the providers
+	APIURL string `env:"API_URL, default=http://localhost:8080"`
+
+	ProviderConfigs map[string]*OIDCProviderConfig
 }
 
 type AppConfig struct {
-	Session *SessionConfig `env:", prefix=SESSION_"`
+	Session    *SessionConfig    `env:", prefix=SESSION_"`
+	Password   *PasswordConfig   `env:", prefix=PASSWORD_"`
+	LoginLimit *LoginLimitConfig `env:", prefix=LOGIN_LIMIT_"`
+	OIDC       *OIDCConfig       `env:", prefix=OIDC_"`
+	Trash      *TrashConfig      `env:", prefix=TRASH_"`
+	AccessLog  *AccessLogConfig  `env:", prefix=ACCESS_LOG_"`
+	Reconcile  *ReconcileConfig  `env:", prefix=RECONCILE_"`
+	Quota      *QuotaConfig      `env:", prefix=QUOTA_"`
+	Upload     *UploadConfig     `env:", prefix=UPLOAD_"`
+	Scan       *ScanConfig       `env:", prefix=SCAN_"`
+	Server     *ServerConfig     `env:", prefix=SERVER_"`
 
 	Debug bool `env:"DEBUG, default=true"`
 
 	DatabaseURL string `env:"DATABASE_URL, required"`
+	// Apply pending migrations on startup, otherwise the server refuses to start until they are applied
+	AutoMigrate bool   `env:"AUTO_MIGRATE, default=false"`
 	RedisURL    string `env:"REDIS_URL,default=redis://localhost:6379/0"`
 
 	PublicURL string `env:"PUBLIC_URL, default=http://localhost:3000"`
+	// Additional origins allowed to make credentialed requests, PublicURL is always allowed
+	AllowedOrigins []string `env:"ALLOWED_ORIGINS"`
+	// Key for CSRF tokens, has to be shared by all instances
+	CSRFSecret string `env:"CSRF_SECRET"`
 
-	Addr    string `env:"ADDR, default=localhost:8080"`
-	DataDir string `env:"DATA_DIR, default=./data"`
+	Addr string `env:"ADDR, default=localhost:8080"`
+	// Separate listen address for /metrics, when empty it is served on Addr
+	AdminAddr string `env:"ADMIN_ADDR"`
+	DataDir   string `env:"DATA_DIR, default=./data"`
 }
 
 var Config AppConfig
@@ -43,6 +168,43 @@ func LoadConfig(ctx context.Context) {
 		log.Fatal().Err(err).Msg("unable to parse config from environment")
 	}
 
+	// Argon2 panics when hashing with parameters below its minimums
+	if p := Config.Password; p.Argon2Iterations < 1 || p.Argon2Parallelism < 1 || p.Argon2Memory < 8*uint32(p.Argon2Parallelism) {
+		log.Fatal().Msg("PASSWORD_ARGON2_ITERATIONS and PASSWORD_ARGON2_PARALLELISM have to be at least 1 and PASSWORD_ARGON2_MEMORY at least 8 KiB per thread")
+	}
+
+	if Config.Scan.ClamdAddr != "" && Config.Scan.StreamMaxLength < Config.Upload.MaxBytes {
+		log.Info().
+			Int64("stream_max_length", Config.Scan.StreamMaxLength).
+			Msg("uploads are limited to SCAN_STREAM_MAX_LENGTH, larger files couldn't be scanned")
+		Config.Upload.MaxBytes = Config.Scan.StreamMaxLength
+	}
+
+	Config.OIDC.ProviderConfigs = make(map[string]*OIDCProviderConfig, len(Config.OIDC.Providers))
+	for _, name := range Config.OIDC.Providers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		provider := &OIDCProviderConfig{}
+
+		err = envconfig.ProcessWith(ctx, &envconfig.Config{
+			Target:   provider,
+			Lookuper: envconfig.PrefixLookuper("OIDC_"+strings.ToUpper(name)+"_", envconfig.OsLookuper()),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msgf("unable to parse config for OIDC provider '%s'", name)
+		}
+
+		Config.OIDC.ProviderConfigs[name] = provider
+	}
+
+	if Config.CSRFSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal().Err(err).Msg("unable to generate CSRF secret")
+		}
+		Config.CSRFSecret = base64.RawURLEncoding.EncodeToString(secret)
+		log.Warn().Msg("CSRF_SECRET is not set, using a random one, CSRF tokens will not work across instances or restarts")
+	}
+
 	Config.DataDir, err = filepath.Abs(Config.DataDir)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to resolve data dir")
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+func GetAPITokens(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	tokens, err := services.GetAPITokens(ctx, middleware.GetUserID(ctx))
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, tokens)
+}
+
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Name      string     `json:"name" validate:"notblank"`
+		Scopes    []string   `json:"scopes" validate:"min=1"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return utils.InvalidField("expires_at", "future", "expires_at must be in the future")
+	}
+
+	token, row, err := services.CreateAPIToken(ctx, middleware.GetUserID(ctx), strings.TrimSpace(req.Name), req.Scopes, req.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{
+		"token":   token,
+		"details": row,
+	})
+}
+
+func DeleteAPIToken(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	if err := services.DeleteAPIToken(ctx, middleware.GetUserID(ctx), id); err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "deleted"})
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// Returns audit log entries newest first. Supports limit, cursor (ID of the last entry of the
+// previous page), entity_type and entity_id query parameters
+func GetAuditLog(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	filter := services.AuditLogFilter{Limit: defaultAuditPageSize}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return utils.InvalidParameter("limit", "limit must be between 1 and "+strconv.Itoa(maxAuditPageSize))
+		}
+		filter.Limit = int32(limit)
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return utils.InvalidParameter("cursor", "invalid cursor")
+		}
+		filter.BeforeID = &id
+	}
+
+	if entityType := query.Get("entity_type"); entityType != "" {
+		filter.EntityType = &entityType
+	}
+
+	if entityIDStr := query.Get("entity_id"); entityIDStr != "" {
+		id, err := strconv.ParseInt(entityIDStr, 10, 64)
+		if err != nil {
+			return utils.InvalidParameter("entity_id", "invalid entity_id")
+		}
+		filter.EntityID = &id
+	}
+
+	entries, err := services.GetAuditLog(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	var nextCursor *string
+	if len(entries) == int(filter.Limit) {
+		cursor := strconv.FormatInt(entries[len(entries)-1].ID, 10)
+		nextCursor = &cursor
+	}
+
+	return utils.JSONResponse(w, utils.H{
+		"items":       entries,
+		"next_cursor": nextCursor,
+	})
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+func GetFolders(w http.ResponseWriter, r *http.Request) error {
+	folders, err := services.GetFolders(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, folders)
+}
+
+// Creates a folder, inside parent_id when it is set
+func CreateFolder(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Name     string `json:"name" validate:"notblank,max=100"`
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	folder, err := services.CreateFolder(ctx, middleware.GetUserID(ctx), req.ParentID, strings.TrimSpace(req.Name))
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, folder)
+}
+
+func RenameFolder(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Name string `json:"name" validate:"notblank,max=100"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	folder, err := services.RenameFolder(r.Context(), id, strings.TrimSpace(req.Name))
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, folder)
+}
+
+// Moves a folder into parent_id, a null parent_id moves it to the top level
+func MoveFolder(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	folder, err := services.MoveFolder(r.Context(), id, req.ParentID)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, folder)
+}
+
+// Removes a folder, only empty folders can be removed
+func DeleteFolder(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := services.DeleteFolder(r.Context(), id); err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "deleted"})
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+// Liveness only tells that the process is able to serve requests, dependencies are not checked
+// so that an outage of the database doesn't get every instance restarted
+func Livez(w http.ResponseWriter, r *http.Request) error {
+	return utils.JSONResponse(w, utils.H{"status": services.HealthOK})
+}
+
+// Readiness checks the dependencies and responds with 503 when any of them is unavailable
+func Readyz(w http.ResponseWriter, r *http.Request) error {
+	readiness, ok := services.CheckReadiness(r.Context())
+
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(readiness)
+}
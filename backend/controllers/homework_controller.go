@@ -1,97 +1,97 @@
 package controllers
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/lowtierkakish/praktiline-too/middleware"
 	"github.com/lowtierkakish/praktiline-too/services"
 	"github.com/lowtierkakish/praktiline-too/utils"
-	"github.com/rs/zerolog"
 )
 
 var validTypes = map[string]bool{
-	"kodutöö":    true,
-	"tunnitöö":   true,
+	"kodutöö":     true,
+	"tunnitöö":    true,
 	"kontrolltöö": true,
 }
 
-func GetHomework(w http.ResponseWriter, r *http.Request) {
+// Returns a page of homework. Supports limit, cursor, sort (day, created_at, -created_at or
+// subject) and the subject, type, day and created_after filters
+func GetHomework(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	homework, err := services.GetAllHomework(ctx)
+	query := r.URL.Query()
+
+	page, err := parsePage(r)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to get homework")
-		utils.JSONErrorMessage(w, "unable to get homework", http.StatusInternalServerError)
-		return
+		return err
 	}
-	utils.JSONResponse(w, homework)
-}
 
-func CreateHomework(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	filter := services.HomeworkFilter{
+		Subject: strings.TrimSpace(query.Get("subject")),
+		Type:    query.Get("type"),
+	}
 
-	var req struct {
-		Subject     string `json:"subject"`
-		Description string `json:"description"`
-		Day         int16  `json:"day"`
-		Type        string `json:"type"`
+	if filter.Type != "" && !validTypes[filter.Type] {
+		return utils.InvalidParameter("type", "invalid type")
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.JSONErrorMessage(w, "invalid request format", http.StatusBadRequest)
-		return
+	if dayStr := query.Get("day"); dayStr != "" {
+		day, err := strconv.ParseInt(dayStr, 10, 16)
+		if err != nil || day < 1 || day > 7 {
+			return utils.InvalidParameter("day", "day must be between 1 and 7")
+		}
+		filter.Day = int16(day)
 	}
 
-	req.Subject = strings.TrimSpace(req.Subject)
-	req.Description = strings.TrimSpace(req.Description)
+	if filter.CreatedAfter, err = parseTimeParam(r, "created_after"); err != nil {
+		return err
+	}
 
-	if req.Subject == "" || req.Description == "" {
-		utils.JSONErrorMessage(w, "subject and description are required", http.StatusBadRequest)
-		return
+	homework, next, err := services.ListHomework(ctx, filter, page)
+	if err != nil {
+		return err
 	}
 
-	if req.Day < 1 || req.Day > 7 {
-		utils.JSONErrorMessage(w, "day must be between 1 and 7", http.StatusBadRequest)
-		return
+	return writePage(w, r, homework, next)
+}
+
+func CreateHomework(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Subject     string `json:"subject" validate:"notblank"`
+		Description string `json:"description" validate:"notblank"`
+		Day         int16  `json:"day" validate:"min=1,max=7"`
+		Type        string `json:"type" validate:"omitempty,oneof=kodutöö tunnitöö kontrolltöö"`
 	}
 
-	if req.Type == "" {
-		req.Type = "kodutöö"
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
 	}
 
-	if !validTypes[req.Type] {
-		utils.JSONErrorMessage(w, "invalid type", http.StatusBadRequest)
-		return
+	if req.Type == "" {
+		req.Type = "kodutöö"
 	}
 
-	hw, err := services.CreateHomework(ctx, req.Subject, req.Description, req.Day, req.Type)
+	hw, err := services.CreateHomework(ctx, middleware.GetUserID(ctx), strings.TrimSpace(req.Subject), strings.TrimSpace(req.Description), req.Day, req.Type)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to create homework")
-		utils.JSONErrorMessage(w, "unable to create homework", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	utils.JSONResponse(w, hw)
+	return utils.JSONResponse(w, hw)
 }
 
-func DeleteHomework(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+func DeleteHomework(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
 	if err != nil {
-		utils.JSONErrorMessage(w, "invalid id", http.StatusBadRequest)
-		return
+		return err
 	}
 
-	if err := services.DeleteHomework(ctx, id); err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to delete homework")
-		utils.JSONErrorMessage(w, "unable to delete homework", http.StatusInternalServerError)
-		return
+	if err := services.DeleteHomework(r.Context(), id); err != nil {
+		return err
 	}
 
-	utils.JSONResponse(w, utils.H{"message": "deleted"})
-}
\ No newline at end of file
+	return utils.JSONResponse(w, utils.H{"message": "deleted"})
+}
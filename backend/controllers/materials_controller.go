@@ -1,151 +1,235 @@
 package controllers
 
 import (
-	"encoding/json"
-	"io"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/metrics"
+	"github.com/lowtierkakish/praktiline-too/middleware"
 	"github.com/lowtierkakish/praktiline-too/services"
 	"github.com/lowtierkakish/praktiline-too/utils"
 	"github.com/rs/zerolog"
 )
 
-func GetMaterials(w http.ResponseWriter, r *http.Request) {
+// Returns a page of materials. Supports limit, cursor, sort (-created_at, created_at or name)
+// and the type (image, file or link), created_after, folder (an ID or none for the top level)
+// and tag filters, tag can be repeated. Files that weren't found clean by the scanner are only
+// listed for their uploader
+func GetMaterials(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	materials, err := services.GetAllMaterials(ctx)
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	filter := services.MaterialFilter{
+		Type:   r.URL.Query().Get("type"),
+		Viewer: middleware.GetUserID(ctx),
+	}
+
+	if filter.Type != "" && filter.Type != "image" && filter.Type != "file" && filter.Type != "link" {
+		return utils.InvalidParameter("type", "type must be image, file or link")
+	}
+
+	if filter.CreatedAfter, err = parseTimeParam(r, "created_after"); err != nil {
+		return err
+	}
+
+	switch folder := r.URL.Query().Get("folder"); folder {
+	case "":
+	case "none":
+		filter.Unfiled = true
+	default:
+		id, err := strconv.ParseInt(folder, 10, 64)
+		if err != nil {
+			return utils.InvalidParameter("folder", "folder must be a folder id or none")
+		}
+		filter.FolderID = &id
+	}
+
+	if filter.Tags, err = services.NormalizeTags("tag", r.URL.Query()["tag"]); err != nil {
+		return err
+	}
+
+	materials, next, err := services.ListMaterials(ctx, filter, page)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to get materials")
-		utils.JSONErrorMessage(w, "unable to get materials", http.StatusInternalServerError)
-		return
+		return err
 	}
-	utils.JSONResponse(w, materials)
+
+	return writePage(w, r, materials, next)
 }
 
-func UploadImage(w http.ResponseWriter, r *http.Request) {
+func UploadImage(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	r.Body = http.MaxBytesReader(w, r.Body, 10<<20) // 10MB max
 
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		utils.JSONErrorMessage(w, "fail too large (max 10MB)", http.StatusBadRequest)
-		return
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return utils.ErrRequestTooLarge
+		}
+		return utils.ErrBadRequest
 	}
 
 	name := strings.TrimSpace(r.FormValue("name"))
 	if name == "" {
-		utils.JSONErrorMessage(w, "name is required", http.StatusBadRequest)
-		return
+		return utils.InvalidField("name", "required", "name is required")
 	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		utils.JSONErrorMessage(w, "file is required", http.StatusBadRequest)
-		return
+		return utils.InvalidField("file", "required", "file is required")
 	}
 	defer file.Close()
 
 	ext := strings.ToLower(filepath.Ext(header.Filename))
 	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" {
-		utils.JSONErrorMessage(w, "only images allowed (jpg, png, gif, webp)", http.StatusBadRequest)
-		return
+		return utils.InvalidField("file", "file_type", "only images allowed (jpg, png, gif, webp)")
 	}
 
-	randomStr, err := utils.GenerateRandomStringURLSafe(16)
-	if err != nil {
-		utils.JSONErrorMessage(w, "server error", http.StatusInternalServerError)
-		return
+	userID := middleware.GetUserID(ctx)
+	if err := services.CheckStorageQuota(ctx, userID, header.Size); err != nil {
+		return err
 	}
-	filename := randomStr + ext
 
-	// Create data dir if it doesn't exist
-	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
-		utils.JSONErrorMessage(w, "server error", http.StatusInternalServerError)
-		return
+	received, err := services.ReceiveFile(file, header.Filename)
+	if err != nil {
+		return err
 	}
 
-	savePath := filepath.Join(config.Config.DataDir, filename)
-	out, err := os.Create(savePath)
+	material, err := services.CreateFileMaterial(ctx, userID, name, "image", received)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to save file")
-		utils.JSONErrorMessage(w, "unable to save file", http.StatusInternalServerError)
-		return
+		// The temporary file is only consumed when the material was created
+		if err := os.Remove(received.TempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			zerolog.Ctx(ctx).Error().Err(err).Str("file", received.TempPath).Msg("unable to remove uploaded file")
+		}
+		return err
+	}
+
+	metrics.UploadedBytes.Add(float64(received.Size))
+
+	return utils.JSONResponse(w, material)
+}
+
+func AddLink(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Name string `json:"name" validate:"notblank"`
+		URL  string `json:"url" validate:"notblank"`
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(out, file); err != nil {
-		utils.JSONErrorMessage(w, "unable to save file", http.StatusInternalServerError)
-		return
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
 	}
 
-	material, err := services.CreateMaterial(ctx, name, "image", filename)
+	material, err := services.CreateLink(ctx, middleware.GetUserID(ctx), strings.TrimSpace(req.Name), strings.TrimSpace(req.URL))
 	if err != nil {
-		os.Remove(savePath)
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to save material")
-		utils.JSONErrorMessage(w, "unable to save material", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	utils.JSONResponse(w, material)
+	return utils.JSONResponse(w, material)
 }
 
-func AddLink(w http.ResponseWriter, r *http.Request) {
+// Returns the bytes uploaded by the current user and by the whole class, with their quotas
+func GetStorageUsage(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	r.Body = http.MaxBytesReader(w, r.Body, 4096)
 
-	var req struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
+	usage, err := services.GetStorageUsage(ctx, middleware.GetUserID(ctx))
+	if err != nil {
+		return err
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.JSONErrorMessage(w, "invalid request format", http.StatusBadRequest)
-		return
+	return utils.JSONResponse(w, usage)
+}
+
+func DeleteMaterial(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
 	}
 
-	req.Name = strings.TrimSpace(req.Name)
-	req.URL = strings.TrimSpace(req.URL)
+	if _, err := services.DeleteMaterial(r.Context(), id); err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "deleted"})
+}
+
+// Moves the materials into folder_id, a null folder_id moves them to the top level
+func MoveMaterials(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 65536)
+
+	var req struct {
+		IDs      []int64 `json:"ids" validate:"min=1,max=500"`
+		FolderID *int64  `json:"folder_id"`
+	}
 
-	if req.Name == "" || req.URL == "" {
-		utils.JSONErrorMessage(w, "name and url are required", http.StatusBadRequest)
-		return
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
 	}
 
-	material, err := services.CreateMaterial(ctx, req.Name, "link", req.URL)
+	materials, err := services.MoveMaterials(r.Context(), req.IDs, req.FolderID)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to save link")
-		utils.JSONErrorMessage(w, "unable to save link", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	utils.JSONResponse(w, material)
+	return utils.JSONResponse(w, materials)
 }
 
-func DeleteMaterial(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// Adds the tags in add to the materials and removes the ones in remove
+func TagMaterials(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 65536)
+
+	var req struct {
+		IDs    []int64  `json:"ids" validate:"min=1,max=500"`
+		Add    []string `json:"add" validate:"max=20"`
+		Remove []string `json:"remove" validate:"max=20"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
 
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	add, err := services.NormalizeTags("add", req.Add)
 	if err != nil {
-		utils.JSONErrorMessage(w, "invalid id", http.StatusBadRequest)
-		return
+		return err
+	}
+	remove, err := services.NormalizeTags("remove", req.Remove)
+	if err != nil {
+		return err
 	}
 
-	row, err := services.DeleteMaterial(ctx, id)
+	materials, err := services.TagMaterials(r.Context(), req.IDs, add, remove)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to delete material")
-		utils.JSONErrorMessage(w, "unable to delete material", http.StatusInternalServerError)
-		return
+		return err
+	}
+
+	return utils.JSONResponse(w, materials)
+}
+
+// Moves the materials to the trash, either all of them or none
+func DeleteMaterials(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 65536)
+
+	var req struct {
+		IDs []int64 `json:"ids" validate:"min=1,max=500"`
 	}
 
-	if row.Type == "image" {
-		filePath := filepath.Join(config.Config.DataDir, row.URL)
-		os.Remove(filePath)
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	materials, err := services.DeleteMaterials(r.Context(), req.IDs)
+	if err != nil {
+		return err
 	}
 
-	utils.JSONResponse(w, utils.H{"message": "deleted"})
+	return utils.JSONResponse(w, utils.H{"message": "deleted", "count": len(materials)})
 }
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/rs/zerolog"
+)
+
+const cookieOIDCStateKey = "praktiline_too_oidc_state"
+
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	provider := chi.URLParam(r, "provider")
+
+	state, authURL, err := services.StartOIDCLogin(ctx, provider)
+	if errors.Is(err, services.ErrUnknownOIDCProvider) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("start OIDC login with %s: %w", provider, err)
+	}
+
+	// Binds the state to this browser so that the callback can't be replayed in another one
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieOIDCStateKey,
+		Value:    state,
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+		Path:     "/api/auth/oidc",
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// Always redirects back to the frontend, errors are passed in the query of the sign-in page.
+// Users with two-factor authentication get the login challenge in a cookie and are sent to the
+// sign-in page with two_factor=1 to enter the code
+func OIDCCallback(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+	provider := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieOIDCStateKey,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api/auth/oidc",
+	})
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		logger.Info().Str("provider", provider).Str("error", providerErr).Msg("OIDC login was not completed")
+		return redirectToSignIn(w, r, url.Values{"error": {"oidc"}})
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(cookieOIDCStateKey)
+	if err != nil || state == "" || cookie.Value != state {
+		return redirectToSignIn(w, r, url.Values{"error": {"oidc_state"}})
+	}
+
+	userID, err := services.FinishOIDCLogin(ctx, provider, state, query.Get("code"))
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCEmailNotVerified) {
+			return redirectToSignIn(w, r, url.Values{"error": {"oidc_email"}})
+		}
+
+		logger.Error().Err(err).Str("provider", provider).Msg("unable to finish OIDC login")
+		return redirectToSignIn(w, r, url.Values{"error": {"oidc"}})
+	}
+
+	twoFactor, err := services.TwoFactorEnabled(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user", userID).Msg("unable to check two-factor authentication")
+		return redirectToSignIn(w, r, url.Values{"error": {"oidc"}})
+	}
+
+	if twoFactor {
+		challenge, err := services.CreateLoginChallenge(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Int64("user", userID).Msg("unable to create login challenge")
+			return redirectToSignIn(w, r, url.Values{"error": {"oidc"}})
+		}
+
+		// The challenge is kept out of the URL, where it would end up in the history and referrers
+		setLoginChallenge(w, challenge)
+
+		return redirectToSignIn(w, r, url.Values{"two_factor": {"1"}})
+	}
+
+	sessionID, err := services.CreateSession(ctx, r, userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user", userID).Msg("unable to create session")
+		return redirectToSignIn(w, r, url.Values{"error": {"oidc"}})
+	}
+
+	middleware.SetSessionID(w, sessionID)
+
+	http.Redirect(w, r, strings.TrimSuffix(config.Config.PublicURL, "/")+"/home", http.StatusFound)
+	return nil
+}
+
+func redirectToSignIn(w http.ResponseWriter, r *http.Request, params url.Values) error {
+	http.Redirect(w, r, strings.TrimSuffix(config.Config.PublicURL, "/")+"/auth/sign-in?"+params.Encode(), http.StatusFound)
+	return nil
+}
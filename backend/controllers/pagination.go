@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+// Reads the limit, cursor and sort query parameters
+func parsePage(r *http.Request) (services.Page, error) {
+	query := r.URL.Query()
+
+	page := services.Page{
+		Limit:  services.DefaultPageSize,
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseUint(limitStr, 10, 64)
+		if err != nil || limit < 1 || limit > services.MaxPageSize {
+			return page, utils.InvalidParameter("limit", "limit must be between 1 and "+strconv.Itoa(services.MaxPageSize))
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
+
+// Writes a page of items together with the cursor of the next page, which is also
+// advertised in the Link header
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, nextCursor string) error {
+	var next *string
+	if nextCursor != "" {
+		next = &nextCursor
+
+		query := r.URL.Query()
+		query.Set("cursor", nextCursor)
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+`>; rel="next"`)
+	}
+
+	return utils.JSONResponse(w, utils.H{
+		"items":       items,
+		"next_cursor": next,
+	})
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+// Reads the numeric id URL parameter
+func idParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, utils.InvalidParameter("id", "invalid id")
+	}
+	return id, nil
+}
+
+// Reads an optional RFC 3339 timestamp query parameter
+func parseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, utils.InvalidParameter(name, name+" must be an RFC 3339 timestamp")
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+const maxSearchQueryLength = 200
+
+// Searches everything the request is allowed to read. Supports q (required), type (homework,
+// material or schedule) and limit query parameters
+func Search(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		return utils.InvalidParameter("q", "q is required")
+	}
+	if utf8.RuneCountInString(q) > maxSearchQueryLength {
+		return utils.InvalidParameter("q", "q must be at most "+strconv.Itoa(maxSearchQueryLength)+" characters")
+	}
+
+	limit := int64(services.DefaultSearchResults)
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || limit < 1 || limit > services.MaxSearchResults {
+			return utils.InvalidParameter("limit", "limit must be between 1 and "+strconv.Itoa(services.MaxSearchResults))
+		}
+	}
+
+	requested := query.Get("type")
+	if _, ok := services.SearchTypes[requested]; requested != "" && !ok {
+		return utils.InvalidParameter("type", "type must be homework, material or schedule")
+	}
+
+	// API tokens only search the resources they can read
+	var types []string
+	for itemType, resource := range services.SearchTypes {
+		if (requested == "" || requested == itemType) && middleware.HasScope(ctx, resource+":read") {
+			types = append(types, itemType)
+		}
+	}
+	if len(types) == 0 {
+		return middleware.ErrMissingScope
+	}
+
+	results, err := services.Search(ctx, q, types, int32(limit))
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"items": results})
+}
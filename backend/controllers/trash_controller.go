@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+func GetTrash(w http.ResponseWriter, r *http.Request) error {
+	trash, err := services.GetTrash(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, trash)
+}
+
+func RestoreFromTrash(w http.ResponseWriter, r *http.Request) error {
+	id, err := idParam(r)
+	if err != nil {
+		return err
+	}
+
+	restored, err := services.RestoreFromTrash(r.Context(), chi.URLParam(r, "type"), id)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, restored)
+}
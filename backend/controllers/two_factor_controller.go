@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	uri, err := services.SetupTwoFactor(ctx, middleware.GetUserID(ctx))
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"otpauth_uri": uri})
+}
+
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		Code string `json:"code" validate:"notblank"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	codes, err := services.EnableTwoFactor(ctx, middleware.GetUserID(ctx), req.Code)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"recovery_codes": codes})
+}
+
+// Disables two-factor authentication, the password is confirmed like in ChangePassword
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code" validate:"notblank"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	sessionID, _ := middleware.GetSessionID(r)
+
+	if err := services.DisableTwoFactor(ctx, middleware.GetUserID(ctx), sessionID, req.Password, req.Code); err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "two-factor authentication disabled"})
+}
+
+const cookieLoginChallengeKey = "praktiline_too_login_challenge"
+
+// Keeps the challenge of a login that was completed through OIDC until the code is entered
+func setLoginChallenge(w http.ResponseWriter, challenge string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieLoginChallengeKey,
+		Value:    challenge,
+		MaxAge:   int(services.LoginChallengeDuration.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+		Path:     "/api/users/login/2fa",
+	})
+}
+
+func removeLoginChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieLoginChallengeKey,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+		Path:     "/api/users/login/2fa",
+	})
+}
+
+// Second step of the login for users with two-factor authentication enabled. The challenge is
+// taken from the request, or from the cookie set by the OIDC callback when it is left out
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code" validate:"notblank"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	if req.Challenge == "" {
+		cookie, err := r.Cookie(cookieLoginChallengeKey)
+		if err != nil {
+			return services.ErrChallengeNotFound
+		}
+		req.Challenge = cookie.Value
+	}
+
+	userID, err := services.CompleteLoginChallenge(ctx, r.RemoteAddr, req.Challenge, req.Code)
+	var limitErr *services.RateLimitError
+	if errors.As(err, &limitErr) {
+		return rateLimited(limitErr)
+	} else if errors.Is(err, services.ErrInvalidTwoFactor) || errors.Is(err, services.ErrTwoFactorNotSetUp) {
+		return utils.NewHTTPError(http.StatusUnauthorized, "invalid_two_factor_code", "invalid code")
+	} else if errors.Is(err, services.ErrChallengeNotFound) {
+		removeLoginChallenge(w)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	removeLoginChallenge(w)
+
+	sessionID, err := services.CreateSession(ctx, r, userID)
+	if err != nil {
+		return fmt.Errorf("create session for user %d: %w", userID, err)
+	}
+
+	middleware.SetSessionID(w, sessionID)
+
+	return utils.JSONResponse(w, utils.H{
+		"id": userID,
+	})
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+// Resumable uploads work in three steps: POST /uploads announces the file and returns the upload,
+// PATCH /uploads/{id} appends chunks at the offset given in the Upload-Offset header, and
+// POST /uploads/{id}/finish verifies the SHA-256 checksum and creates the material. After an
+// interruption GET /uploads/{id} returns the offset to continue from
+const uploadOffsetHeader = "Upload-Offset"
+
+func writeUpload(w http.ResponseWriter, upload services.Upload) error {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	return utils.JSONResponse(w, upload)
+}
+
+func CreateUpload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	var req struct {
+		Name     string `json:"name" validate:"notblank"`
+		Filename string `json:"filename" validate:"notblank"`
+		Size     int64  `json:"size" validate:"gte=1"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	upload, err := services.CreateUpload(ctx, middleware.GetUserID(ctx), strings.TrimSpace(req.Name), req.Filename, req.Size)
+	if err != nil {
+		return err
+	}
+
+	return writeUpload(w, upload)
+}
+
+func GetUpload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	upload, err := services.GetUpload(ctx, middleware.GetUserID(ctx), chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+
+	return writeUpload(w, upload)
+}
+
+func AppendUpload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/offset+octet-stream" && contentType != "application/octet-stream" {
+		return utils.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", "chunks must be sent as application/offset+octet-stream")
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		return utils.InvalidParameter(uploadOffsetHeader, uploadOffsetHeader+" header must be a non-negative integer")
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.Config.Upload.ChunkBytes)
+
+	upload, err := services.AppendUpload(ctx, middleware.GetUserID(ctx), chi.URLParam(r, "id"), offset, r.Body)
+	if err != nil {
+		// For rejected chunks the client continues from the offset that was actually stored
+		if httpErr := utils.AsHTTPError(err); upload.ID != "" && httpErr.Status() < http.StatusInternalServerError {
+			return httpErr.WithHeader(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		}
+		return err
+	}
+
+	return writeUpload(w, upload)
+}
+
+func FinishUpload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		Checksum string `json:"checksum" validate:"required,len=64,hexadecimal"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	material, err := services.FinishUpload(ctx, middleware.GetUserID(ctx), chi.URLParam(r, "id"), req.Checksum)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, material)
+}
+
+func CancelUpload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	if err := services.CancelUpload(ctx, middleware.GetUserID(ctx), chi.URLParam(r, "id")); err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "cancelled"})
+}
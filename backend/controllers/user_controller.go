@@ -1,12 +1,14 @@
 package controllers
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
 
 	"github.com/lowtierkakish/praktiline-too/middleware"
@@ -14,145 +16,252 @@ import (
 	"github.com/lowtierkakish/praktiline-too/utils"
 )
 
-func RegisterUser(w http.ResponseWriter, r *http.Request) {
+var errTooManyLoginAttempts = utils.NewHTTPError(http.StatusTooManyRequests, "too_many_login_attempts", "too many login attempts, try again later")
+
+func RegisterUser(w http.ResponseWriter, r *http.Request) error {
 	var err error
 	var userRequest struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Email     string `json:"email"`
-		Password  string `json:"password"`
+		FirstName string `json:"first_name" validate:"notblank"`
+		LastName  string `json:"last_name" validate:"notblank"`
+		Email     string `json:"email" validate:"notblank"`
+		Password  string `json:"password" validate:"notblank"`
 	}
 
 	ctx := r.Context()
-	logger := zerolog.Ctx(ctx)
 	r.Body = http.MaxBytesReader(w, r.Body, 1024)
 
-	if err = json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
-		utils.JSONErrorMessage(w, "Invalid request format", http.StatusBadRequest)
-		return
+	if err = utils.JSONBody(r, &userRequest); err != nil {
+		return err
 	}
 
 	userRequest.FirstName = strings.TrimSpace(userRequest.FirstName)
 	userRequest.LastName = strings.TrimSpace(userRequest.LastName)
-	userRequest.Email = strings.TrimSpace(strings.ToLower(userRequest.Email))
 	userRequest.Password = strings.TrimSpace(userRequest.Password)
 
 	userRequest.Email, err = utils.SanitazeEmail(userRequest.Email)
 	if err != nil {
-		utils.JSONErrorMessage(w, err.Error(), http.StatusBadRequest)
-		return
+		return utils.InvalidField("email", "email", err.Error())
 	}
 
-	if userRequest.FirstName == "" || userRequest.LastName == "" || userRequest.Password == "" {
-		utils.JSONErrorMessage(w, "All fields are required", http.StatusBadRequest)
-		return
-	}
-
-	strength := services.EvaluatePasswordStrength(userRequest.Password)
-	if strength == services.PasswordWeak {
-		utils.JSONErrorMessage(w, "Password is too weak. Use a combination of uppercase, lowercase, numbers, and symbols", http.StatusBadRequest)
-		return
+	err = services.ValidateNewPassword(ctx, userRequest.Password, userRequest.Email, userRequest.FirstName, userRequest.LastName)
+	if err != nil {
+		return passwordRejected(err, "password")
 	}
 
 	userID, err := services.CreateUser(ctx, userRequest.FirstName, userRequest.LastName, userRequest.Email, userRequest.Password)
 	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			utils.JSONErrorMessage(w, "User with this email already exists", http.StatusConflict)
-		} else {
-			utils.JSONErrorMessage(w, "Error creating user: "+err.Error(), http.StatusInternalServerError)
-		}
-		return
+		return err
 	}
 
 	sessionID, err := services.CreateSession(ctx, r, userID)
 	if err != nil {
-		logger.Error().Err(err).Int64("user", userID).Msg("unable to create session")
-		utils.JSONErrorMessage(w, err.Error(), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("create session for user %d: %w", userID, err)
 	}
 
 	middleware.SetSessionID(w, sessionID)
 
-	utils.JSONResponse(w, utils.H{
+	return utils.JSONResponse(w, utils.H{
 		"id": userID,
 	})
 }
 
-func LoginUser(w http.ResponseWriter, r *http.Request) {
+func LoginUser(w http.ResponseWriter, r *http.Request) error {
 	var loginRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"notblank"`
+		Password string `json:"password" validate:"notblank"`
 	}
 
 	ctx := r.Context()
 	logger := zerolog.Ctx(ctx)
 	r.Body = http.MaxBytesReader(w, r.Body, 1024)
 
-	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
-		utils.JSONErrorMessage(w, err.Error(), http.StatusBadRequest)
-		return
+	if err := utils.JSONBody(r, &loginRequest); err != nil {
+		return err
 	}
 
-	if strings.TrimSpace(loginRequest.Email) == "" || strings.TrimSpace(loginRequest.Password) == "" {
-		utils.JSONErrorMessage(w, "Email and password are required", http.StatusBadRequest)
-		return
+	if err := services.CheckLoginAllowed(ctx, r.RemoteAddr, loginRequest.Email); err != nil {
+		if limitErr, ok := err.(*services.RateLimitError); ok {
+			return rateLimited(limitErr)
+		}
+		logger.Error().Err(err).Msg("unable to check login rate limit")
 	}
 
 	userID, err := services.Authenticate(ctx, loginRequest.Email, loginRequest.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		logger.Info().Err(err).Msg("unable to authenticate user")
+		if err := services.RecordLoginFailure(ctx, r.RemoteAddr, loginRequest.Email); err != nil {
+			logger.Error().Err(err).Msg("unable to record failed login")
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	twoFactor, err := services.TwoFactorEnabled(ctx, userID)
 	if err != nil {
-		logger.Error().Err(err).Str("email", loginRequest.Email).Msg("unable to authenticate user")
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
-		return
+		return fmt.Errorf("check two-factor authentication of user %d: %w", userID, err)
+	}
+
+	// With two-factor authentication the failures are only cleared once the code was accepted,
+	// otherwise every correct password would allow more codes to be guessed
+	if twoFactor {
+		challenge, err := services.CreateLoginChallenge(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("create login challenge for user %d: %w", userID, err)
+		}
+
+		return utils.JSONResponse(w, utils.H{
+			"two_factor_required": true,
+			"challenge":           challenge,
+		})
 	}
 
+	services.RecordLoginSuccess(ctx, loginRequest.Email)
+
 	sessionID, err := services.CreateSession(ctx, r, userID)
 	if err != nil {
-		logger.Error().Err(err).Int64("user", userID).Msg("unable to create session")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("create session for user %d: %w", userID, err)
 	}
 
 	middleware.SetSessionID(w, sessionID)
 
-	utils.JSONResponse(w, utils.H{
+	return utils.JSONResponse(w, utils.H{
 		"id": userID,
 	})
 }
 
-func GetMe(w http.ResponseWriter, r *http.Request) {
+// Converts the password feedback to field errors of the given field
+func passwordRejected(err error, field string) error {
+	var rejected *services.PasswordRejectedError
+	if !errors.As(err, &rejected) {
+		return err
+	}
+
+	fields := make([]utils.FieldError, len(rejected.Feedback))
+	for i, feedback := range rejected.Feedback {
+		fields[i] = utils.FieldError{Field: field, Code: feedback.Code, Message: feedback.Message}
+	}
+
+	return utils.InvalidField(field, fields[0].Code, fields[0].Message).WithFields(fields[1:]...)
+}
+
+func rateLimited(err *services.RateLimitError) error {
+	return errTooManyLoginAttempts.WithHeader("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+}
+
+func GetMe(w http.ResponseWriter, r *http.Request) error {
+	user, err := middleware.GetUser(r.Context())
+	if errors.Is(err, pgx.ErrNoRows) {
+		return utils.ErrUnauthorized
+	} else if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(w, user)
+}
+
+// Changes the password, current_password can be left out by users who logged in through OIDC
+// and have no password yet, they have to have logged in recently instead
+func ChangePassword(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	logger := zerolog.Ctx(ctx)
-	user, err := middleware.GetUser(ctx)
+	userID := middleware.GetUserID(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password" validate:"required"`
+	}
+
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	currentSessionID, _ := middleware.GetSessionID(r)
+
+	if err := services.ChangePassword(ctx, userID, currentSessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		return passwordRejected(err, "new_password")
+	}
+
+	sessionID, err := services.CreateSession(ctx, r, userID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			utils.JSONErrorMessage(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to create session")
+		middleware.RemoveSessionID(w)
+		return utils.NewHTTPError(http.StatusInternalServerError, "session_error", "password changed, please log in again")
+	}
 
-		logger.Error().Err(err).Msg("unable get user (me)")
-		http.Error(w, "unable get user", http.StatusInternalServerError)
-		return
+	middleware.SetSessionID(w, sessionID)
+
+	return utils.JSONResponse(w, utils.H{"message": "password changed"})
+}
+
+// Returns the token that has to be sent in the X-CSRF-Token header with state-changing requests
+func GetCSRFToken(w http.ResponseWriter, r *http.Request) error {
+	sessionID, err := middleware.GetSessionID(r)
+	if err != nil {
+		return utils.ErrUnauthorized
 	}
 
-	utils.JSONResponse(w, user)
+	return utils.JSONResponse(w, utils.H{"token": middleware.CSRFToken(sessionID)})
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	log := zerolog.Ctx(ctx)
+func Logout(w http.ResponseWriter, r *http.Request) error {
 	sessionID, err := middleware.GetSessionID(r)
 	if err != nil {
-		utils.JSONErrorMessage(w, err.Error(), http.StatusInternalServerError)
-		return
+		return utils.ErrUnauthorized
 	}
 
 	middleware.RemoveSessionID(w)
 
-	if err = services.DestroySession(ctx, sessionID); err != nil {
-		log.Error().Err(err).Msg("unable to logout user")
-		utils.JSONErrorMessage(w, "unable to logout user", http.StatusInternalServerError)
-		return
+	if err = services.DestroySession(r.Context(), sessionID); err != nil {
+		return fmt.Errorf("destroy session: %w", err)
+	}
+
+	return utils.JSONResponse(w, utils.H{"message": "logged out"})
+}
+
+func ExportMyData(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+
+	archive, err := services.OpenUserDataArchive(ctx, userID)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="praktiline-too-data-%d.zip"`, userID))
+
+	if err := archive.Write(w); err != nil {
+		// The archive was partly sent already, aborting the connection makes the download fail
+		// instead of leaving the client with a truncated zip
+		zerolog.Ctx(ctx).Error().Err(err).Int64("user", userID).Msg("unable to write data export")
+		panic(http.ErrAbortHandler)
+	}
+
+	return nil
+}
+
+// Deletes the account, the password is confirmed like in ChangePassword
+func DeleteMe(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024)
+
+	var req struct {
+		Password string `json:"password"`
 	}
 
-	utils.JSONResponse(w, utils.H{"message": "logged out"})
+	if err := utils.JSONBody(r, &req); err != nil {
+		return err
+	}
+
+	sessionID, _ := middleware.GetSessionID(r)
+
+	if err := services.DeleteUser(ctx, middleware.GetUserID(ctx), sessionID, req.Password); err != nil {
+		return err
+	}
+
+	middleware.RemoveSessionID(w)
+
+	return utils.JSONResponse(w, utils.H{"message": "deleted"})
 }
@@ -86,6 +86,19 @@ func InitializeCache(ctx context.Context) {
 	log.Info().Msg("successfully connected to redis database")
 }
 
+// Closes the database pool and the cache client, nothing can be queried afterwards
+func Close() {
+	if DB != nil {
+		DB.Close()
+	}
+	if Pool != nil {
+		Pool.Close()
+	}
+	if Cache != nil {
+		Cache.Close()
+	}
+}
+
 func Tx(ctx context.Context) (pgx.Tx, error) {
 	return Pool.BeginTx(ctx, pgx.TxOptions{})
 }
@@ -101,9 +114,11 @@ func Pattern(in string) string {
 		return in
 	}
 
+	// The escape character itself has to be escaped first, otherwise the escapes added for
+	// % and _ would be doubled
 	in = strings.ToLower(in)
-	in = strings.ReplaceAll(in, "%", "\\%")
 	in = strings.ReplaceAll(in, "\\", "\\\\")
+	in = strings.ReplaceAll(in, "%", "\\%")
 	in = strings.ReplaceAll(in, "_", "\\_")
 	return "%" + in + "%"
 }
@@ -0,0 +1,13 @@
+-- +goose Up
+alter table homework add column created_by bigint references users (id) on delete set null;
+alter table schedule add column created_by bigint references users (id) on delete set null;
+alter table materials add column created_by bigint references users (id) on delete set null;
+
+create index idx_homework_created_by on homework (created_by);
+create index idx_schedule_created_by on schedule (created_by);
+create index idx_materials_created_by on materials (created_by);
+
+-- +goose Down
+alter table materials drop column created_by;
+alter table schedule drop column created_by;
+alter table homework drop column created_by;
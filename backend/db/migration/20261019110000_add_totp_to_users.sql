@@ -0,0 +1,20 @@
+-- +goose Up
+alter table users add column totp_secret text;
+alter table users add column totp_enabled boolean not null default false;
+alter table users add column totp_last_step bigint not null default 0;
+
+create table recovery_codes (
+    id bigint primary key generated always as identity,
+    user_id bigint not null references users (id) on delete cascade,
+    code_hash bytea not null,
+    used_at timestamptz
+);
+
+create index idx_recovery_codes_user_id on recovery_codes (user_id);
+
+-- +goose Down
+drop table recovery_codes;
+
+alter table users drop column totp_last_step;
+alter table users drop column totp_enabled;
+alter table users drop column totp_secret;
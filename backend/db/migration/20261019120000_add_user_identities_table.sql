@@ -0,0 +1,15 @@
+-- +goose Up
+create table user_identities (
+    id bigint primary key generated always as identity,
+    user_id bigint not null references users (id) on delete cascade,
+    provider text not null,
+    subject text not null,
+    email text not null,
+    created_at timestamptz not null default now(),
+    unique (provider, subject)
+);
+
+create index idx_user_identities_user_id on user_identities (user_id);
+
+-- +goose Down
+drop table user_identities;
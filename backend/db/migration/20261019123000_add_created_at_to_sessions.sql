@@ -0,0 +1,7 @@
+-- +goose Up
+-- Sessions created before the column existed have no creation time and are never recent
+alter table sessions add column created_at timestamptz;
+alter table sessions alter column created_at set default now();
+
+-- +goose Down
+alter table sessions drop column created_at;
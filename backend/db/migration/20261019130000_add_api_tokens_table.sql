@@ -0,0 +1,16 @@
+-- +goose Up
+create table api_tokens (
+    id bigint primary key generated always as identity,
+    user_id bigint not null references users (id) on delete cascade,
+    name text not null,
+    token_hash bytea not null unique,
+    scopes text[] not null,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+create index idx_api_tokens_user_id on api_tokens (user_id);
+
+-- +goose Down
+drop table api_tokens;
@@ -0,0 +1,22 @@
+-- +goose Up
+alter table users add column role text not null default 'student';
+
+create table audit_log (
+    id bigint primary key generated always as identity,
+    actor_id bigint references users (id) on delete set null,
+    action text not null,
+    entity_type text not null,
+    entity_id bigint,
+    before jsonb,
+    after jsonb,
+    request_id text,
+    ip text,
+    created_at timestamptz not null default now()
+);
+
+create index idx_audit_log_entity on audit_log (entity_type, entity_id);
+
+-- +goose Down
+drop table audit_log;
+
+alter table users drop column role;
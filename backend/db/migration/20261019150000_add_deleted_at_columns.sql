@@ -0,0 +1,24 @@
+-- +goose Up
+alter table homework add column deleted_at timestamptz;
+alter table schedule add column deleted_at timestamptz;
+alter table materials add column deleted_at timestamptz;
+
+-- Trashed entries must not block the slot for a new entry
+alter table schedule drop constraint schedule_day_slot_key;
+create unique index schedule_day_slot_key on schedule (day, slot) where deleted_at is null;
+
+create index idx_homework_deleted_at on homework (deleted_at) where deleted_at is not null;
+create index idx_schedule_deleted_at on schedule (deleted_at) where deleted_at is not null;
+create index idx_materials_deleted_at on materials (deleted_at) where deleted_at is not null;
+
+-- +goose Down
+delete from homework where deleted_at is not null;
+delete from schedule where deleted_at is not null;
+delete from materials where deleted_at is not null;
+
+drop index schedule_day_slot_key;
+alter table schedule add constraint schedule_day_slot_key unique (day, slot);
+
+alter table materials drop column deleted_at;
+alter table schedule drop column deleted_at;
+alter table homework drop column deleted_at;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Postgres has no Estonian stemmer, so the configuration is based on simple (lowercasing only)
+-- with unaccent in front of it, so that "kontrolltöö" is also found by "kontrolltoo"
+create extension if not exists unaccent;
+
+create text search configuration estonian_simple (copy = simple);
+alter text search configuration estonian_simple
+    alter mapping for asciiword, asciihword, hword_asciipart, word, hword, hword_part
+    with unaccent, simple;
+
+create index idx_homework_search on homework using gin ((
+    setweight(to_tsvector('estonian_simple', subject), 'A') ||
+    setweight(to_tsvector('estonian_simple', description), 'B')
+));
+
+create index idx_materials_search on materials using gin ((
+    setweight(to_tsvector('estonian_simple', name), 'A') ||
+    setweight(to_tsvector('estonian_simple', case when type = 'link' then url else '' end), 'C')
+));
+
+create index idx_schedule_search on schedule using gin ((
+    to_tsvector('estonian_simple', subject)
+));
+
+-- +goose Down
+drop index idx_schedule_search;
+drop index idx_materials_search;
+drop index idx_homework_search;
+
+-- unaccent is left installed, it may have existed before or be used by other objects
+drop text search configuration estonian_simple;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Size of uploaded files in bytes, used for storage quotas. Existing uploads start at 0, their
+-- sizes are filled in by the file reconciler
+alter table materials add column size bigint not null default 0;
+
+-- +goose Down
+alter table materials drop column size;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Uploaded files are stored once per content, materials refer to them by file name. Files uploaded
+-- before this table existed have no row and belong to a single material
+create table blobs (
+    hash text primary key,
+    filename text not null unique,
+    size bigint not null,
+    ref_count integer not null check (ref_count >= 0),
+    created_at timestamptz not null default now()
+);
+
+-- +goose Down
+drop table blobs;
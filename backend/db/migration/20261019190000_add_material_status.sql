@@ -0,0 +1,18 @@
+-- +goose Up
+-- Uploaded files are pending until they have been scanned, rejected files are quarantined.
+-- Existing materials were uploaded before scanning and are considered clean
+alter table materials add column status text not null default 'clean'
+    check (status in ('pending', 'clean', 'rejected'));
+
+-- Set when a file couldn't be scanned for a reason that would fail again right away, for example
+-- a file over the size limit of the scanner. The scan is retried after this time
+alter table materials add column scan_retry_at timestamptz;
+
+create index idx_materials_pending on materials (id) where status = 'pending';
+
+-- Files are only served once every material stored in them is clean, few materials are not
+create index idx_materials_unscanned_url on materials (url) where status <> 'clean';
+
+-- +goose Down
+alter table materials drop column scan_retry_at;
+alter table materials drop column status;
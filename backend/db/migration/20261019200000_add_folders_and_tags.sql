@@ -0,0 +1,23 @@
+-- +goose Up
+create table folders (
+    id bigint primary key generated always as identity,
+    parent_id bigint references folders (id),
+    name text not null,
+    created_by bigint references users (id) on delete set null,
+    created_at timestamptz not null default now()
+);
+
+-- Names are unique among siblings, top level folders have no parent
+create unique index idx_folders_parent_name on folders (coalesce(parent_id, 0), lower(name));
+
+-- Materials of a removed folder move to the top level
+alter table materials add column folder_id bigint references folders (id) on delete set null;
+alter table materials add column tags text[] not null default '{}' check (cardinality(tags) <= 20);
+
+create index idx_materials_folder_id on materials (folder_id);
+create index idx_materials_tags on materials using gin (tags);
+
+-- +goose Down
+alter table materials drop column tags;
+alter table materials drop column folder_id;
+drop table folders;
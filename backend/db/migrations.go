@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+	"github.com/rs/zerolog"
+)
+
+// Migrations contains the goose migrations of the schema
+//
+//go:embed migration/*.sql
+var Migrations embed.FS
+
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// Returns a goose provider for the embedded migrations. Migrations are run while holding a
+// Postgres advisory lock, so instances started at the same time don't apply them concurrently
+func Migrator() (*goose.Provider, error) {
+	migrations, err := fs.Sub(Migrations, "migration")
+	if err != nil {
+		return nil, err
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+
+	return goose.NewProvider(goose.DialectPostgres, DB, migrations,
+		goose.WithSessionLocker(locker),
+		goose.WithDisableGlobalRegistry(true),
+	)
+}
+
+// Applies all pending migrations
+func Migrate(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+
+	migrator, err := Migrator()
+	if err != nil {
+		return err
+	}
+
+	results, err := migrator.Up(ctx)
+	for _, result := range results {
+		log.Info().Int64("version", result.Source.Version).Dur("duration", result.Duration).Msgf("applied migration %s", result.Source.Path)
+	}
+
+	return err
+}
+
+// Returns the version of the database schema and the version of the newest embedded migration
+func SchemaVersions(ctx context.Context) (current, latest int64, err error) {
+	migrator, err := Migrator()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return migrator.GetVersions(ctx)
+}
+
+// Returns ErrSchemaBehind if migrations known to this build have not been applied. A newer schema
+// is fine, it happens while another instance with newer migrations is being rolled out
+func CheckSchema(ctx context.Context) error {
+	current, latest, err := SchemaVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read schema version: %w", err)
+	}
+
+	if current < latest {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaBehind, current, latest)
+	}
+
+	return nil
+}
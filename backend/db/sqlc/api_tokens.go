@@ -0,0 +1,99 @@
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateAPITokenParams struct {
+	UserID    int64
+	Name      string
+	TokenHash []byte
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+type GetAPITokenByHashRow struct {
+	ID     int64
+	UserID int64
+	Scopes []string
+}
+
+const createAPIToken = `
+insert into api_tokens (user_id, name, token_hash, scopes, expires_at)
+values ($1, $2, $3, $4, $5)
+returning id, user_id, name, scopes, expires_at, last_used_at, created_at
+`
+
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) (APIToken, error) {
+	row := q.db.QueryRow(ctx, createAPIToken, arg.UserID, arg.Name, arg.TokenHash, arg.Scopes, arg.ExpiresAt)
+	var t APIToken
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+	return t, err
+}
+
+const getAPITokensByUser = `
+select id, user_id, name, scopes, expires_at, last_used_at, created_at
+from api_tokens
+where user_id = $1
+order by created_at desc
+`
+
+func (q *Queries) GetAPITokensByUser(ctx context.Context, userID int64) ([]APIToken, error) {
+	rows, err := q.db.Query(ctx, getAPITokensByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+const getAPITokenByHash = `
+select id, user_id, scopes
+from api_tokens
+where token_hash = $1 and (expires_at is null or expires_at > now())
+`
+
+func (q *Queries) GetAPITokenByHash(ctx context.Context, tokenHash []byte) (GetAPITokenByHashRow, error) {
+	row := q.db.QueryRow(ctx, getAPITokenByHash, tokenHash)
+	var t GetAPITokenByHashRow
+	err := row.Scan(&t.ID, &t.UserID, &t.Scopes)
+	return t, err
+}
+
+const touchAPIToken = `update api_tokens set last_used_at = now() where id = $1`
+
+func (q *Queries) TouchAPIToken(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, touchAPIToken, id)
+	return err
+}
+
+const deleteAPIToken = `delete from api_tokens where id = $1 and user_id = $2`
+
+// DeleteAPIToken returns false if the user has no token with the given ID
+func (q *Queries) DeleteAPIToken(ctx context.Context, id, userID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, deleteAPIToken, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
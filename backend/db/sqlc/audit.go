@@ -0,0 +1,96 @@
+package sqlc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type AuditLogEntry struct {
+	ID         int64           `json:"id"`
+	ActorID    *int64          `json:"actor_id"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   *int64          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  *string         `json:"request_id"`
+	IP         *string         `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type CreateAuditLogEntryParams struct {
+	ActorID    *int64
+	Action     string
+	EntityType string
+	EntityID   *int64
+	Before     []byte
+	After      []byte
+	RequestID  *string
+	IP         *string
+}
+
+const createAuditLogEntry = `
+insert into audit_log (actor_id, action, entity_type, entity_id, before, after, request_id, ip)
+values ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+func (q *Queries) CreateAuditLogEntry(ctx context.Context, arg CreateAuditLogEntryParams) error {
+	_, err := q.db.Exec(ctx, createAuditLogEntry,
+		arg.ActorID,
+		arg.Action,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Before,
+		arg.After,
+		arg.RequestID,
+		arg.IP,
+	)
+	return err
+}
+
+type GetAuditLogParams struct {
+	Limit      int32
+	BeforeID   *int64
+	EntityType *string
+	EntityID   *int64
+}
+
+const getAuditLog = `
+select id, actor_id, action, entity_type, entity_id, before, after, request_id, ip, created_at
+from audit_log
+where ($2::bigint is null or id < $2)
+  and ($3::text is null or entity_type = $3)
+  and ($4::bigint is null or entity_id = $4)
+order by id desc
+limit $1
+`
+
+func (q *Queries) GetAuditLog(ctx context.Context, arg GetAuditLogParams) ([]AuditLogEntry, error) {
+	rows, err := q.db.Query(ctx, getAuditLog, arg.Limit, arg.BeforeID, arg.EntityType, arg.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []AuditLogEntry
+	for rows.Next() {
+		var e AuditLogEntry
+		if err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.Action,
+			&e.EntityType,
+			&e.EntityID,
+			&e.Before,
+			&e.After,
+			&e.RequestID,
+			&e.IP,
+			&e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,66 @@
+package sqlc
+
+import (
+	"context"
+)
+
+type AcquireBlobParams struct {
+	Hash     string
+	Filename string
+	Size     int64
+}
+
+const acquireBlob = `
+insert into blobs (hash, filename, size, ref_count)
+values ($1, $2, $3, 1)
+on conflict (hash) do update set ref_count = blobs.ref_count + 1
+returning filename
+`
+
+// AcquireBlob adds a reference to the blob with the given hash, creating it if it doesn't exist,
+// and returns the file name of the blob. The row stays locked until the transaction ends
+func (q *Queries) AcquireBlob(ctx context.Context, arg AcquireBlobParams) (string, error) {
+	var filename string
+	err := q.db.QueryRow(ctx, acquireBlob, arg.Hash, arg.Filename, arg.Size).Scan(&filename)
+	return filename, err
+}
+
+const releaseBlob = `
+update blobs set ref_count = ref_count - 1
+where filename = $1
+returning ref_count
+`
+
+// ReleaseBlob removes a reference to the blob stored in the given file and returns the number of
+// references left. Files without a blob return pgx.ErrNoRows
+func (q *Queries) ReleaseBlob(ctx context.Context, filename string) (int32, error) {
+	var refCount int32
+	err := q.db.QueryRow(ctx, releaseBlob, filename).Scan(&refCount)
+	return refCount, err
+}
+
+const deleteBlob = `
+delete from blobs where filename = $1 and ref_count = 0
+`
+
+func (q *Queries) DeleteBlob(ctx context.Context, filename string) error {
+	_, err := q.db.Exec(ctx, deleteBlob, filename)
+	return err
+}
+
+const blobExists = `
+select exists (select 1 from blobs where filename = $1)
+`
+
+func (q *Queries) BlobExists(ctx context.Context, filename string) (bool, error) {
+	var exists bool
+	err := q.db.QueryRow(ctx, blobExists, filename).Scan(&exists)
+	return exists, err
+}
+
+// LockBlobFile blocks until no other transaction is acquiring the blob stored in the given file
+// or removing the file. The lock is released when the transaction ends
+func (q *Queries) LockBlobFile(ctx context.Context, filename string) error {
+	_, err := q.db.Exec(ctx, "select pg_advisory_xact_lock(hashtext('blobs'), hashtext($1))", filename)
+	return err
+}
@@ -0,0 +1,138 @@
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+type Folder struct {
+	ID        int64     `json:"id"`
+	ParentID  *int64    `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedBy *int64    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateFolderParams struct {
+	ParentID  *int64
+	Name      string
+	CreatedBy *int64
+}
+
+const folderColumns = `id, parent_id, name, created_by, created_at`
+
+func scanFolder(row interface{ Scan(...any) error }) (Folder, error) {
+	var f Folder
+	err := row.Scan(&f.ID, &f.ParentID, &f.Name, &f.CreatedBy, &f.CreatedAt)
+	return f, err
+}
+
+const getFolders = `
+select ` + folderColumns + `
+from folders
+order by lower(name), id
+`
+
+// GetFolders returns every folder, the tree is built from the parent IDs
+func (q *Queries) GetFolders(ctx context.Context) ([]Folder, error) {
+	rows, err := q.db.Query(ctx, getFolders)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Folder
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, f)
+	}
+	return items, rows.Err()
+}
+
+const getFolder = `
+select ` + folderColumns + `
+from folders
+where id = $1
+`
+
+func (q *Queries) GetFolder(ctx context.Context, id int64) (Folder, error) {
+	return scanFolder(q.db.QueryRow(ctx, getFolder, id))
+}
+
+const createFolder = `
+insert into folders (parent_id, name, created_by)
+values ($1, $2, $3)
+returning ` + folderColumns
+
+func (q *Queries) CreateFolder(ctx context.Context, arg CreateFolderParams) (Folder, error) {
+	return scanFolder(q.db.QueryRow(ctx, createFolder, arg.ParentID, arg.Name, arg.CreatedBy))
+}
+
+const renameFolder = `
+update folders set name = $2
+where id = $1
+returning ` + folderColumns
+
+func (q *Queries) RenameFolder(ctx context.Context, id int64, name string) (Folder, error) {
+	return scanFolder(q.db.QueryRow(ctx, renameFolder, id, name))
+}
+
+const moveFolder = `
+update folders set parent_id = $2
+where id = $1
+returning ` + folderColumns
+
+func (q *Queries) MoveFolder(ctx context.Context, id int64, parentID *int64) (Folder, error) {
+	return scanFolder(q.db.QueryRow(ctx, moveFolder, id, parentID))
+}
+
+const isFolderWithin = `
+with recursive ancestors as (
+    select id, parent_id from folders where id = $1
+    union all
+    select f.id, f.parent_id from folders f join ancestors a on f.id = a.parent_id
+)
+select exists (select 1 from ancestors where id = $2)
+`
+
+// IsFolderWithin reports whether the folder is the given ancestor or one of its descendants
+func (q *Queries) IsFolderWithin(ctx context.Context, id, ancestorID int64) (bool, error) {
+	var within bool
+	err := q.db.QueryRow(ctx, isFolderWithin, id, ancestorID).Scan(&within)
+	return within, err
+}
+
+const folderIsEmpty = `
+select not exists (select 1 from folders where parent_id = $1)
+    and not exists (select 1 from materials where folder_id = $1 and deleted_at is null)
+`
+
+// FolderIsEmpty reports whether the folder has no subfolders and no materials outside the trash
+func (q *Queries) FolderIsEmpty(ctx context.Context, id int64) (bool, error) {
+	var empty bool
+	err := q.db.QueryRow(ctx, folderIsEmpty, id).Scan(&empty)
+	return empty, err
+}
+
+const deleteFolder = `
+delete from folders
+where id = $1
+returning ` + folderColumns
+
+// DeleteFolder removes the folder, trashed materials in it are restored to the top level
+func (q *Queries) DeleteFolder(ctx context.Context, id int64) (Folder, error) {
+	return scanFolder(q.db.QueryRow(ctx, deleteFolder, id))
+}
+
+// Key of the transaction level advisory lock that serializes changes to the folder tree
+const folderTreeLockKey = 4608130927
+
+// LockFolderTree blocks until no other transaction is moving or deleting folders, so that
+// concurrent moves can't create a cycle. The lock is released when the transaction ends
+func (q *Queries) LockFolderTree(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, "select pg_advisory_xact_lock($1)", folderTreeLockKey)
+	return err
+}
@@ -0,0 +1,66 @@
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+type UserIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateUserIdentityParams struct {
+	UserID   int64
+	Provider string
+	Subject  string
+	Email    string
+}
+
+const getUserIDByIdentity = `select user_id from user_identities where provider = $1 and subject = $2`
+
+func (q *Queries) GetUserIDByIdentity(ctx context.Context, provider, subject string) (int64, error) {
+	row := q.db.QueryRow(ctx, getUserIDByIdentity, provider, subject)
+	var userID int64
+	err := row.Scan(&userID)
+	return userID, err
+}
+
+const getUserIdentitiesByUser = `
+select id, user_id, provider, subject, email, created_at
+from user_identities
+where user_id = $1
+order by created_at asc
+`
+
+func (q *Queries) GetUserIdentitiesByUser(ctx context.Context, userID int64) ([]UserIdentity, error) {
+	rows, err := q.db.Query(ctx, getUserIdentitiesByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []UserIdentity
+	for rows.Next() {
+		var i UserIdentity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
+
+const createUserIdentity = `
+insert into user_identities (user_id, provider, subject, email)
+values ($1, $2, $3, $4)
+`
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.Exec(ctx, createUserIdentity, arg.UserID, arg.Provider, arg.Subject, arg.Email)
+	return err
+}
@@ -6,66 +6,292 @@ import (
 )
 
 type Material struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Type      string    `json:"type"`
-	URL       string    `json:"url"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type"`
+	URL       string     `json:"url"`
+	Size      int64      `json:"size"`
+	Status    string     `json:"status"`
+	FolderID  *int64     `json:"folder_id"`
+	Tags      []string   `json:"tags"`
+	CreatedBy *int64     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CreateMaterialParams struct {
-	Name string
-	Type string
-	URL  string
+	Name      string
+	Type      string
+	URL       string
+	Size      int64
+	Status    string
+	CreatedBy *int64
 }
 
-type DeleteMaterialRow struct {
-	URL  string
-	Type string
+// HasFile reports whether the material is an uploaded file stored in the data directory,
+// every type except link is
+func (m Material) HasFile() bool {
+	return m.Type != "link"
+}
+
+const materialColumns = `id, name, type, url, size, status, folder_id, tags, created_by, created_at, deleted_at`
+
+func scanMaterial(row interface{ Scan(...any) error }) (Material, error) {
+	var m Material
+	err := row.Scan(&m.ID, &m.Name, &m.Type, &m.URL, &m.Size, &m.Status, &m.FolderID, &m.Tags, &m.CreatedBy, &m.CreatedAt, &m.DeletedAt)
+	return m, err
+}
+
+func (q *Queries) queryMaterials(ctx context.Context, sql string, args ...any) ([]Material, error) {
+	rows, err := q.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Material
+	for rows.Next() {
+		m, err := scanMaterial(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	return items, rows.Err()
 }
 
 const getAllMaterials = `
-select id, name, type, url, created_at
+select ` + materialColumns + `
 from materials
+where deleted_at is null
 order by created_at desc
 `
 
 func (q *Queries) GetAllMaterials(ctx context.Context) ([]Material, error) {
-	rows, err := q.db.Query(ctx, getAllMaterials)
+	return q.queryMaterials(ctx, getAllMaterials)
+}
+
+const getMaterialsByAuthor = `
+select ` + materialColumns + `
+from materials
+where created_by = $1
+order by created_at asc
+`
+
+func (q *Queries) GetMaterialsByAuthor(ctx context.Context, createdBy *int64) ([]Material, error) {
+	return q.queryMaterials(ctx, getMaterialsByAuthor, createdBy)
+}
+
+const createMaterial = `
+insert into materials (name, type, url, size, status, created_by)
+values ($1, $2, $3, $4, $5, $6)
+returning ` + materialColumns
+
+func (q *Queries) CreateMaterial(ctx context.Context, arg CreateMaterialParams) (Material, error) {
+	return scanMaterial(q.db.QueryRow(ctx, createMaterial, arg.Name, arg.Type, arg.URL, arg.Size, arg.Status, arg.CreatedBy))
+}
+
+const trashMaterial = `
+update materials set deleted_at = now()
+where id = $1 and deleted_at is null
+returning ` + materialColumns
+
+func (q *Queries) TrashMaterial(ctx context.Context, id int64) (Material, error) {
+	return scanMaterial(q.db.QueryRow(ctx, trashMaterial, id))
+}
+
+const trashMaterials = `
+update materials set deleted_at = now()
+where id = any($1) and deleted_at is null
+returning ` + materialColumns
+
+// TrashMaterials moves the given materials to the trash, IDs that don't exist or are trashed
+// already are skipped
+func (q *Queries) TrashMaterials(ctx context.Context, ids []int64) ([]Material, error) {
+	return q.queryMaterials(ctx, trashMaterials, ids)
+}
+
+const moveMaterials = `
+update materials set folder_id = $2
+where id = any($1) and deleted_at is null
+returning ` + materialColumns
+
+// MoveMaterials moves the given materials into the folder, or to the top level when it is nil
+func (q *Queries) MoveMaterials(ctx context.Context, ids []int64, folderID *int64) ([]Material, error) {
+	return q.queryMaterials(ctx, moveMaterials, ids, folderID)
+}
+
+const tagMaterials = `
+update materials set tags = array(
+    select distinct tag from unnest(tags || coalesce($2::text[], '{}')) tag
+    where tag <> all(coalesce($3::text[], '{}'))
+    order by tag
+)
+where id = any($1) and deleted_at is null
+returning ` + materialColumns
+
+// TagMaterials adds the tags in add to the given materials and removes the ones in remove
+func (q *Queries) TagMaterials(ctx context.Context, ids []int64, add, remove []string) ([]Material, error) {
+	return q.queryMaterials(ctx, tagMaterials, ids, add, remove)
+}
+
+const restoreMaterial = `
+update materials set deleted_at = null
+where id = $1 and deleted_at is not null
+returning ` + materialColumns
+
+func (q *Queries) RestoreMaterial(ctx context.Context, id int64) (Material, error) {
+	return scanMaterial(q.db.QueryRow(ctx, restoreMaterial, id))
+}
+
+const getTrashedMaterials = `
+select ` + materialColumns + `
+from materials
+where deleted_at is not null
+order by deleted_at desc
+`
+
+func (q *Queries) GetTrashedMaterials(ctx context.Context) ([]Material, error) {
+	return q.queryMaterials(ctx, getTrashedMaterials)
+}
+
+const purgeMaterials = `
+delete from materials
+where deleted_at < $1
+returning ` + materialColumns
+
+// PurgeMaterials permanently removes materials trashed before the given time,
+// files of the returned uploads have to be removed by the caller
+func (q *Queries) PurgeMaterials(ctx context.Context, deletedBefore time.Time) ([]Material, error) {
+	return q.queryMaterials(ctx, purgeMaterials, deletedBefore)
+}
+
+const getStoredMaterials = `
+select ` + materialColumns + `
+from materials
+where type <> 'link'
+order by id
+`
+
+// GetStoredMaterials returns every uploaded file including trashed ones, their files are
+// still kept until the trash is purged
+func (q *Queries) GetStoredMaterials(ctx context.Context) ([]Material, error) {
+	return q.queryMaterials(ctx, getStoredMaterials)
+}
+
+const deleteStoredMaterialsByAuthor = `
+delete from materials
+where created_by = $1 and type <> 'link'
+returning url
+`
+
+// DeleteStoredMaterialsByAuthor removes every uploaded file of the given user
+// and returns the file names so they can be removed from disk
+func (q *Queries) DeleteStoredMaterialsByAuthor(ctx context.Context, createdBy *int64) ([]string, error) {
+	rows, err := q.db.Query(ctx, deleteStoredMaterialsByAuthor, createdBy)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []Material
+	var items []string
 	for rows.Next() {
-		var m Material
-		if err := rows.Scan(&m.ID, &m.Name, &m.Type, &m.URL, &m.CreatedAt); err != nil {
+		var url string
+		if err := rows.Scan(&url); err != nil {
 			return nil, err
 		}
-		items = append(items, m)
+		items = append(items, url)
 	}
 	return items, rows.Err()
 }
 
-const createMaterial = `
-insert into materials (name, type, url)
-values ($1, $2, $3)
-returning id, name, type, url, created_at
+type StorageUsage struct {
+	// Bytes uploaded by the given user
+	User int64 `json:"user"`
+	// Bytes uploaded by everyone
+	Total int64 `json:"total"`
+}
+
+const getStorageUsage = `
+select
+    coalesce(sum(size) filter (where created_by = $1), 0)::bigint,
+    coalesce(sum(size), 0)::bigint
+from materials
+where type <> 'link'
 `
 
-func (q *Queries) CreateMaterial(ctx context.Context, arg CreateMaterialParams) (Material, error) {
-	row := q.db.QueryRow(ctx, createMaterial, arg.Name, arg.Type, arg.URL)
-	var m Material
-	err := row.Scan(&m.ID, &m.Name, &m.Type, &m.URL, &m.CreatedAt)
-	return m, err
+// GetStorageUsage sums the sizes of uploaded files, trashed ones included since their files
+// are kept until the trash is purged
+func (q *Queries) GetStorageUsage(ctx context.Context, userID int64) (StorageUsage, error) {
+	var usage StorageUsage
+	err := q.db.QueryRow(ctx, getStorageUsage, userID).Scan(&usage.User, &usage.Total)
+	return usage, err
+}
+
+const setMaterialSize = `
+update materials set size = $2 where id = $1
+`
+
+func (q *Queries) SetMaterialSize(ctx context.Context, id int64, size int64) error {
+	_, err := q.db.Exec(ctx, setMaterialSize, id, size)
+	return err
+}
+
+// Key of the transaction level advisory lock that serializes quota checks of uploads
+const storageQuotaLockKey = 4608130926
+
+// LockStorageQuota blocks until no other transaction is checking a storage quota, the lock is
+// released when the transaction ends
+func (q *Queries) LockStorageQuota(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, "select pg_advisory_xact_lock($1)", storageQuotaLockKey)
+	return err
+}
+
+const fileIsUnscanned = `
+select exists (select 1 from materials where url = $1 and status <> 'clean')
+`
+
+// FileIsUnscanned reports whether a material stored in the given file is pending or rejected
+func (q *Queries) FileIsUnscanned(ctx context.Context, url string) (bool, error) {
+	var unscanned bool
+	err := q.db.QueryRow(ctx, fileIsUnscanned, url).Scan(&unscanned)
+	return unscanned, err
+}
+
+const claimPendingMaterial = `
+select ` + materialColumns + `
+from materials
+where status = 'pending' and (scan_retry_at is null or scan_retry_at <= now())
+order by id
+limit 1
+for update skip locked
+`
+
+// ClaimPendingMaterial returns the oldest material waiting for a scan and locks it until the
+// transaction ends, materials locked by other transactions or deferred by DeferScanByURL are
+// skipped
+func (q *Queries) ClaimPendingMaterial(ctx context.Context) (Material, error) {
+	return scanMaterial(q.db.QueryRow(ctx, claimPendingMaterial))
+}
+
+const deferScanByURL = `
+update materials set scan_retry_at = $2
+where url = $1 and status = 'pending'
+`
+
+// DeferScanByURL skips the pending materials stored in the given file until retryAt
+func (q *Queries) DeferScanByURL(ctx context.Context, url string, retryAt time.Time) error {
+	_, err := q.db.Exec(ctx, deferScanByURL, url, retryAt)
+	return err
 }
 
-const deleteMaterial = `delete from materials where id = $1 returning url, type`
+const setStatusByURL = `
+update materials set status = $2
+where url = $1 and (status = 'pending' or $2 = 'rejected')
+returning ` + materialColumns
 
-func (q *Queries) DeleteMaterial(ctx context.Context, id int64) (DeleteMaterialRow, error) {
-	row := q.db.QueryRow(ctx, deleteMaterial, id)
-	var r DeleteMaterialRow
-	err := row.Scan(&r.URL, &r.Type)
-	return r, err
+// SetStatusByURL sets the status of every material stored in the given file. Rejections apply
+// to all of them, other statuses only to pending ones
+func (q *Queries) SetStatusByURL(ctx context.Context, url, status string) ([]Material, error) {
+	return q.queryMaterials(ctx, setStatusByURL, url, status)
 }
@@ -9,20 +9,23 @@ import (
 )
 
 type Homework struct {
-	ID          int64     `json:"id"`
-	Subject     string    `json:"subject"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	Day         int16     `json:"day"`
-	Type        string    `json:"type"`
+	ID          int64      `json:"id"`
+	Subject     string     `json:"subject"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Day         int16      `json:"day"`
+	Type        string     `json:"type"`
+	CreatedBy   *int64     `json:"created_by"`
+	DeletedAt   *time.Time `json:"deleted_at"`
 }
 
 type Session struct {
-	Sid       string    `json:"sid"`
-	UserID    int64     `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Ip        string    `json:"ip"`
-	UserAgent string    `json:"user_agent"`
+	Sid       string     `json:"sid"`
+	UserID    int64      `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Ip        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt *time.Time `json:"created_at"`
 }
 
 type User struct {
@@ -31,4 +34,5 @@ type User struct {
 	LastName  string `json:"last_name"`
 	Email     string `json:"email"`
 	Password  []byte `json:"password"`
+	Role      string `json:"role"`
 }
@@ -11,9 +11,9 @@ import (
 )
 
 const createHomework = `-- name: CreateHomework :one
-insert into homework (subject, description, day, type)
-values ($1, $2, $3, $4)
-returning id, subject, description, day, type, created_at
+insert into homework (subject, description, day, type, created_by)
+values ($1, $2, $3, $4, $5)
+returning id, subject, description, day, type, created_by, created_at
 `
 
 type CreateHomeworkParams struct {
@@ -21,6 +21,7 @@ type CreateHomeworkParams struct {
 	Description string `json:"description"`
 	Day         int16  `json:"day"`
 	Type        string `json:"type"`
+	CreatedBy   *int64 `json:"created_by"`
 }
 
 type CreateHomeworkRow struct {
@@ -29,6 +30,7 @@ type CreateHomeworkRow struct {
 	Description string    `json:"description"`
 	Day         int16     `json:"day"`
 	Type        string    `json:"type"`
+	CreatedBy   *int64    `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -38,6 +40,7 @@ func (q *Queries) CreateHomework(ctx context.Context, arg CreateHomeworkParams)
 		arg.Description,
 		arg.Day,
 		arg.Type,
+		arg.CreatedBy,
 	)
 	var i CreateHomeworkRow
 	err := row.Scan(
@@ -46,6 +49,7 @@ func (q *Queries) CreateHomework(ctx context.Context, arg CreateHomeworkParams)
 		&i.Description,
 		&i.Day,
 		&i.Type,
+		&i.CreatedBy,
 		&i.CreatedAt,
 	)
 	return i, err
@@ -114,12 +118,12 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int64,
 	return id, err
 }
 
-const deleteHomework = `-- name: DeleteHomework :exec
-delete from homework where id = $1
+const deleteUser = `-- name: DeleteUser :exec
+delete from users where id = $1
 `
 
-func (q *Queries) DeleteHomework(ctx context.Context, id int64) error {
-	_, err := q.db.Exec(ctx, deleteHomework, id)
+func (q *Queries) DeleteUser(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
 	return err
 }
 
@@ -147,6 +151,30 @@ func (q *Queries) DestroyAllSessions(ctx context.Context, userID int64) ([]strin
 	return items, nil
 }
 
+const destroyExpiredSessions = `-- name: DestroyExpiredSessions :many
+delete from sessions where expires_at < now() returning sid
+`
+
+func (q *Queries) DestroyExpiredSessions(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, destroyExpiredSessions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		items = append(items, sid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const destroySession = `-- name: DestroySession :exec
 delete from sessions where sid = $1
 `
@@ -157,8 +185,9 @@ func (q *Queries) DestroySession(ctx context.Context, sid string) error {
 }
 
 const getAllHomework = `-- name: GetAllHomework :many
-select id, subject, description, day, type, created_at
+select id, subject, description, day, type, created_by, created_at
 from homework
+where deleted_at is null
 order by day asc, created_at asc
 `
 
@@ -168,6 +197,7 @@ type GetAllHomeworkRow struct {
 	Description string    `json:"description"`
 	Day         int16     `json:"day"`
 	Type        string    `json:"type"`
+	CreatedBy   *int64    `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -186,7 +216,129 @@ func (q *Queries) GetAllHomework(ctx context.Context) ([]GetAllHomeworkRow, erro
 			&i.Description,
 			&i.Day,
 			&i.Type,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHomeworkByAuthor = `-- name: GetHomeworkByAuthor :many
+select id, subject, description, day, type, created_by, created_at
+from homework
+where created_by = $1
+order by created_at asc
+`
+
+type GetHomeworkByAuthorRow struct {
+	ID          int64     `json:"id"`
+	Subject     string    `json:"subject"`
+	Description string    `json:"description"`
+	Day         int16     `json:"day"`
+	Type        string    `json:"type"`
+	CreatedBy   *int64    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (q *Queries) GetHomeworkByAuthor(ctx context.Context, createdBy *int64) ([]GetHomeworkByAuthorRow, error) {
+	rows, err := q.db.Query(ctx, getHomeworkByAuthor, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetHomeworkByAuthorRow
+	for rows.Next() {
+		var i GetHomeworkByAuthorRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Subject,
+			&i.Description,
+			&i.Day,
+			&i.Type,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSessionCreatedAt = `-- name: GetSessionCreatedAt :one
+select created_at from sessions where sid = $1
+`
+
+func (q *Queries) GetSessionCreatedAt(ctx context.Context, sid string) (*time.Time, error) {
+	row := q.db.QueryRow(ctx, getSessionCreatedAt, sid)
+	var created_at *time.Time
+	err := row.Scan(&created_at)
+	return created_at, err
+}
+
+const getSessionsByUser = `-- name: GetSessionsByUser :many
+select expires_at, ip, user_agent from sessions where user_id = $1 order by expires_at desc
+`
+
+type GetSessionsByUserRow struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+func (q *Queries) GetSessionsByUser(ctx context.Context, userID int64) ([]GetSessionsByUserRow, error) {
+	rows, err := q.db.Query(ctx, getSessionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSessionsByUserRow
+	for rows.Next() {
+		var i GetSessionsByUserRow
+		if err := rows.Scan(&i.ExpiresAt, &i.Ip, &i.UserAgent); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTrashedHomework = `-- name: GetTrashedHomework :many
+select id, subject, description, created_at, day, type, created_by, deleted_at from homework
+where deleted_at is not null
+order by deleted_at desc
+`
+
+func (q *Queries) GetTrashedHomework(ctx context.Context) ([]Homework, error) {
+	rows, err := q.db.Query(ctx, getTrashedHomework)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Homework
+	for rows.Next() {
+		var i Homework
+		if err := rows.Scan(
+			&i.ID,
+			&i.Subject,
+			&i.Description,
 			&i.CreatedAt,
+			&i.Day,
+			&i.Type,
+			&i.CreatedBy,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -215,7 +367,7 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEm
 }
 
 const getUserByID = `-- name: GetUserByID :one
-select id, first_name, last_name, email from users where id = $1
+select id, first_name, last_name, email, role from users where id = $1
 `
 
 type GetUserByIDRow struct {
@@ -223,6 +375,7 @@ type GetUserByIDRow struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Email     string `json:"email"`
+	Role      string `json:"role"`
 }
 
 func (q *Queries) GetUserByID(ctx context.Context, id int64) (GetUserByIDRow, error) {
@@ -233,6 +386,7 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (GetUserByIDRow, er
 		&i.FirstName,
 		&i.LastName,
 		&i.Email,
+		&i.Role,
 	)
 	return i, err
 }
@@ -259,6 +413,229 @@ func (q *Queries) GetUserIDBySession(ctx context.Context, sid string) (int64, er
 	return user_id, err
 }
 
+const getUserPasswordByID = `-- name: GetUserPasswordByID :one
+select password from users where id = $1
+`
+
+func (q *Queries) GetUserPasswordByID(ctx context.Context, id int64) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getUserPasswordByID, id)
+	var password []byte
+	err := row.Scan(&password)
+	return password, err
+}
+
+const getUserRole = `-- name: GetUserRole :one
+select role from users where id = $1
+`
+
+func (q *Queries) GetUserRole(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRow(ctx, getUserRole, id)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const listUsers = `-- name: ListUsers :many
+select id, first_name, last_name, email, role from users order by id
+`
+
+type ListUsersRow struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+}
+
+func (q *Queries) ListUsers(ctx context.Context) ([]ListUsersRow, error) {
+	rows, err := q.db.Query(ctx, listUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeHomework = `-- name: PurgeHomework :many
+delete from homework
+where deleted_at < $1::timestamptz
+returning id, subject, description, created_at, day, type, created_by, deleted_at
+`
+
+func (q *Queries) PurgeHomework(ctx context.Context, deletedBefore time.Time) ([]Homework, error) {
+	rows, err := q.db.Query(ctx, purgeHomework, deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Homework
+	for rows.Next() {
+		var i Homework
+		if err := rows.Scan(
+			&i.ID,
+			&i.Subject,
+			&i.Description,
+			&i.CreatedAt,
+			&i.Day,
+			&i.Type,
+			&i.CreatedBy,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreHomework = `-- name: RestoreHomework :one
+update homework set deleted_at = null
+where id = $1 and deleted_at is not null
+returning id, subject, description, created_at, day, type, created_by, deleted_at
+`
+
+func (q *Queries) RestoreHomework(ctx context.Context, id int64) (Homework, error) {
+	row := q.db.QueryRow(ctx, restoreHomework, id)
+	var i Homework
+	err := row.Scan(
+		&i.ID,
+		&i.Subject,
+		&i.Description,
+		&i.CreatedAt,
+		&i.Day,
+		&i.Type,
+		&i.CreatedBy,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const search = `-- name: Search :many
+with search_query as (
+    select websearch_to_tsquery('estonian_simple', $1::text) as q
+), results as (
+    select 'homework'::text as type, h.id, h.subject as title, h.description as body,
+        ts_rank(setweight(to_tsvector('estonian_simple', h.subject), 'A') || setweight(to_tsvector('estonian_simple', h.description), 'B'), s.q) as rank
+    from homework h, search_query s
+    where h.deleted_at is null
+        and 'homework' = any($2::text[])
+        and setweight(to_tsvector('estonian_simple', h.subject), 'A') || setweight(to_tsvector('estonian_simple', h.description), 'B') @@ s.q
+    union all
+    select 'material', m.id, m.name, case when m.type = 'link' then m.url else m.name end,
+        ts_rank(setweight(to_tsvector('estonian_simple', m.name), 'A') || setweight(to_tsvector('estonian_simple', case when m.type = 'link' then m.url else '' end), 'C'), s.q)
+    from materials m, search_query s
+    where m.deleted_at is null
+        and m.status = 'clean'
+        and 'material' = any($2::text[])
+        and (
+            setweight(to_tsvector('estonian_simple', m.name), 'A') || setweight(to_tsvector('estonian_simple', case when m.type = 'link' then m.url else '' end), 'C') @@ s.q
+            or (m.type = 'link' and lower(m.url) like $3::text)
+        )
+    union all
+    select 'schedule', sc.id, sc.subject, sc.subject,
+        ts_rank(to_tsvector('estonian_simple', sc.subject), s.q)
+    from schedule sc, search_query s
+    where sc.deleted_at is null
+        and 'schedule' = any($2::text[])
+        and to_tsvector('estonian_simple', sc.subject) @@ s.q
+    order by rank desc, id
+    limit $4::int
+)
+select r.type, r.id, r.title,
+    ts_headline('estonian_simple', replace(replace(replace(r.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), s.q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') as snippet,
+    r.rank
+from results r, search_query s
+order by r.rank desc, r.id
+`
+
+type SearchParams struct {
+	Query      string   `json:"query"`
+	Types      []string `json:"types"`
+	UrlPattern string   `json:"url_pattern"`
+	MaxResults int32    `json:"max_results"`
+}
+
+type SearchRow struct {
+	Type    string  `json:"type"`
+	ID      int64   `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
+}
+
+func (q *Queries) Search(ctx context.Context, arg SearchParams) ([]SearchRow, error) {
+	rows, err := q.db.Query(ctx, search,
+		arg.Query,
+		arg.Types,
+		arg.UrlPattern,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchRow
+	for rows.Next() {
+		var i SearchRow
+		if err := rows.Scan(
+			&i.Type,
+			&i.ID,
+			&i.Title,
+			&i.Snippet,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const trashHomework = `-- name: TrashHomework :one
+update homework set deleted_at = now()
+where id = $1 and deleted_at is null
+returning id, subject, description, created_at, day, type, created_by, deleted_at
+`
+
+func (q *Queries) TrashHomework(ctx context.Context, id int64) (Homework, error) {
+	row := q.db.QueryRow(ctx, trashHomework, id)
+	var i Homework
+	err := row.Scan(
+		&i.ID,
+		&i.Subject,
+		&i.Description,
+		&i.CreatedAt,
+		&i.Day,
+		&i.Type,
+		&i.CreatedBy,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const updateSessionExpiration = `-- name: UpdateSessionExpiration :exec
 update sessions set expires_at = $1 where sid = $2
 `
@@ -272,3 +649,31 @@ func (q *Queries) UpdateSessionExpiration(ctx context.Context, arg UpdateSession
 	_, err := q.db.Exec(ctx, updateSessionExpiration, arg.ExpiresAt, arg.Sid)
 	return err
 }
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+update users set password = $2 where id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID       int64  `json:"id"`
+	Password []byte `json:"password"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.Password)
+	return err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :exec
+update users set role = $2 where id = $1
+`
+
+type UpdateUserRoleParams struct {
+	ID   int64  `json:"id"`
+	Role string `json:"role"`
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) error {
+	_, err := q.db.Exec(ctx, updateUserRole, arg.ID, arg.Role)
+	return err
+}
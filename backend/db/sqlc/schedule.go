@@ -1,28 +1,36 @@
 package sqlc
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Schedule struct {
-	ID      int64  `json:"id"`
-	Day     int16  `json:"day"`
-	Slot    int16  `json:"slot"`
-	Subject string `json:"subject"`
+	ID        int64      `json:"id"`
+	Day       int16      `json:"day"`
+	Slot      int16      `json:"slot"`
+	Subject   string     `json:"subject"`
+	CreatedBy *int64     `json:"created_by"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type CreateScheduleParams struct {
-	Day     int16  `json:"day"`
-	Slot    int16  `json:"slot"`
-	Subject string `json:"subject"`
+	Day       int16  `json:"day"`
+	Slot      int16  `json:"slot"`
+	Subject   string `json:"subject"`
+	CreatedBy *int64 `json:"created_by"`
 }
 
-const getAllSchedule = `
-select id, day, slot, subject
-from schedule
-order by day asc, slot asc
-`
+const scheduleColumns = `id, day, slot, subject, created_by, deleted_at`
 
-func (q *Queries) GetAllSchedule(ctx context.Context) ([]Schedule, error) {
-	rows, err := q.db.Query(ctx, getAllSchedule)
+func scanSchedule(row interface{ Scan(...any) error }) (Schedule, error) {
+	var s Schedule
+	err := row.Scan(&s.ID, &s.Day, &s.Slot, &s.Subject, &s.CreatedBy, &s.DeletedAt)
+	return s, err
+}
+
+func (q *Queries) querySchedule(ctx context.Context, sql string, args ...any) ([]Schedule, error) {
+	rows, err := q.db.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -30,8 +38,8 @@ func (q *Queries) GetAllSchedule(ctx context.Context) ([]Schedule, error) {
 
 	var items []Schedule
 	for rows.Next() {
-		var s Schedule
-		if err := rows.Scan(&s.ID, &s.Day, &s.Slot, &s.Subject); err != nil {
+		s, err := scanSchedule(rows)
+		if err != nil {
 			return nil, err
 		}
 		items = append(items, s)
@@ -39,23 +47,82 @@ func (q *Queries) GetAllSchedule(ctx context.Context) ([]Schedule, error) {
 	return items, rows.Err()
 }
 
-const createScheduleEntry = `
-insert into schedule (day, slot, subject)
-values ($1, $2, $3)
-on conflict (day, slot) do update set subject = $3
-returning id, day, slot, subject
+const getAllSchedule = `
+select ` + scheduleColumns + `
+from schedule
+where deleted_at is null
+order by day asc, slot asc
+`
+
+func (q *Queries) GetAllSchedule(ctx context.Context) ([]Schedule, error) {
+	return q.querySchedule(ctx, getAllSchedule)
+}
+
+const getScheduleByAuthor = `
+select ` + scheduleColumns + `
+from schedule
+where created_by = $1
+order by day asc, slot asc
+`
+
+func (q *Queries) GetScheduleByAuthor(ctx context.Context, createdBy *int64) ([]Schedule, error) {
+	return q.querySchedule(ctx, getScheduleByAuthor, createdBy)
+}
+
+const getScheduleEntryBySlot = `
+select ` + scheduleColumns + `
+from schedule
+where day = $1 and slot = $2 and deleted_at is null
 `
 
+func (q *Queries) GetScheduleEntryBySlot(ctx context.Context, day, slot int16) (Schedule, error) {
+	return scanSchedule(q.db.QueryRow(ctx, getScheduleEntryBySlot, day, slot))
+}
+
+const createScheduleEntry = `
+insert into schedule (day, slot, subject, created_by)
+values ($1, $2, $3, $4)
+on conflict (day, slot) where deleted_at is null do update set subject = $3, created_by = $4
+returning ` + scheduleColumns
+
 func (q *Queries) CreateScheduleEntry(ctx context.Context, arg CreateScheduleParams) (Schedule, error) {
-	row := q.db.QueryRow(ctx, createScheduleEntry, arg.Day, arg.Slot, arg.Subject)
-	var s Schedule
-	err := row.Scan(&s.ID, &s.Day, &s.Slot, &s.Subject)
-	return s, err
+	return scanSchedule(q.db.QueryRow(ctx, createScheduleEntry, arg.Day, arg.Slot, arg.Subject, arg.CreatedBy))
+}
+
+const trashScheduleEntry = `
+update schedule set deleted_at = now()
+where id = $1 and deleted_at is null
+returning ` + scheduleColumns
+
+func (q *Queries) TrashScheduleEntry(ctx context.Context, id int64) (Schedule, error) {
+	return scanSchedule(q.db.QueryRow(ctx, trashScheduleEntry, id))
+}
+
+const restoreScheduleEntry = `
+update schedule set deleted_at = null
+where id = $1 and deleted_at is not null
+returning ` + scheduleColumns
+
+func (q *Queries) RestoreScheduleEntry(ctx context.Context, id int64) (Schedule, error) {
+	return scanSchedule(q.db.QueryRow(ctx, restoreScheduleEntry, id))
+}
+
+const getTrashedSchedule = `
+select ` + scheduleColumns + `
+from schedule
+where deleted_at is not null
+order by deleted_at desc
+`
+
+func (q *Queries) GetTrashedSchedule(ctx context.Context) ([]Schedule, error) {
+	return q.querySchedule(ctx, getTrashedSchedule)
 }
 
-const deleteScheduleEntry = `delete from schedule where id = $1`
+const purgeSchedule = `
+delete from schedule
+where deleted_at < $1
+returning ` + scheduleColumns
 
-func (q *Queries) DeleteScheduleEntry(ctx context.Context, id int64) error {
-	_, err := q.db.Exec(ctx, deleteScheduleEntry, id)
-	return err
+func (q *Queries) PurgeSchedule(ctx context.Context, deletedBefore time.Time) ([]Schedule, error) {
+	return q.querySchedule(ctx, purgeSchedule, deletedBefore)
 }
@@ -0,0 +1,86 @@
+package sqlc
+
+import (
+	"context"
+)
+
+type UserTOTP struct {
+	Secret   *string
+	Enabled  bool
+	LastStep int64
+}
+
+const getUserTOTP = `select totp_secret, totp_enabled, totp_last_step from users where id = $1`
+
+func (q *Queries) GetUserTOTP(ctx context.Context, id int64) (UserTOTP, error) {
+	row := q.db.QueryRow(ctx, getUserTOTP, id)
+	var t UserTOTP
+	err := row.Scan(&t.Secret, &t.Enabled, &t.LastStep)
+	return t, err
+}
+
+const setUserTOTPSecret = `
+update users set totp_secret = $2, totp_enabled = false, totp_last_step = 0
+where id = $1
+`
+
+func (q *Queries) SetUserTOTPSecret(ctx context.Context, id int64, secret string) error {
+	_, err := q.db.Exec(ctx, setUserTOTPSecret, id, secret)
+	return err
+}
+
+const enableUserTOTP = `update users set totp_enabled = true where id = $1 and totp_secret is not null`
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, enableUserTOTP, id)
+	return err
+}
+
+const disableUserTOTP = `
+update users set totp_secret = null, totp_enabled = false, totp_last_step = 0
+where id = $1
+`
+
+func (q *Queries) DisableUserTOTP(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, disableUserTOTP, id)
+	return err
+}
+
+const useUserTOTPStep = `update users set totp_last_step = $2 where id = $1 and totp_last_step < $2`
+
+// UseUserTOTPStep marks the time step as used and returns false if it (or a later one) was already used
+func (q *Queries) UseUserTOTPStep(ctx context.Context, id int64, step int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, useUserTOTPStep, id, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+const deleteRecoveryCodes = `delete from recovery_codes where user_id = $1`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int64) error {
+	_, err := q.db.Exec(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const createRecoveryCode = `insert into recovery_codes (user_id, code_hash) values ($1, $2)`
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, userID int64, codeHash []byte) error {
+	_, err := q.db.Exec(ctx, createRecoveryCode, userID, codeHash)
+	return err
+}
+
+const useRecoveryCode = `
+update recovery_codes set used_at = now()
+where user_id = $1 and code_hash = $2 and used_at is null
+`
+
+// UseRecoveryCode marks the recovery code as used and returns false if it does not exist or was already used
+func (q *Queries) UseRecoveryCode(ctx context.Context, userID int64, codeHash []byte) (bool, error) {
+	tag, err := q.db.Exec(ctx, useRecoveryCode, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/lowtierkakish/praktiline-too/cli"
 	"github.com/lowtierkakish/praktiline-too/config"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/routes"
+	"github.com/lowtierkakish/praktiline-too/services"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -31,21 +38,121 @@ func prettierLogging() {
 	}
 }
 
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       config.Config.Server.ReadTimeout,
+		ReadHeaderTimeout: config.Config.Server.ReadHeaderTimeout,
+		WriteTimeout:      config.Config.Server.WriteTimeout,
+		IdleTimeout:       config.Config.Server.IdleTimeout,
+	}
+}
+
+// Starts the server in the background, errors other than the server being shut down are sent to errs
+func serve(server *http.Server, name string, errs chan<- error) {
+	go func() {
+		log.Info().Msgf("%s running on %s", name, server.Addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("%s failed: %w", name, err)
+		}
+	}()
+}
+
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	initLogging()
 	config.LoadConfig(ctx)
 	prettierLogging()
 
+	if len(os.Args) > 1 {
+		if err := cli.Run(ctx, os.Args[1:]); err != nil {
+			log.Fatal().Err(err).Msgf("%s failed", os.Args[1])
+		}
+		return
+	}
+
+	if err := run(ctx, stop); err != nil {
+		log.Fatal().Err(err).Msg("server stopped")
+	}
+}
+
+// Serves until ctx is cancelled or a server fails, then drains the requests, stops the background
+// workers and closes the connections. stop restores the default signal handling
+func run(ctx context.Context, stop context.CancelFunc) error {
 	db.ConnectDB(ctx)
 	db.InitializeCache(ctx)
+	defer db.Close()
+	services.InitScanner()
+
+	if config.Config.AutoMigrate {
+		if err := db.Migrate(ctx); err != nil {
+			return fmt.Errorf("unable to migrate database: %w", err)
+		}
+	}
+
+	// Serving requests with an outdated schema would fail in confusing ways
+	if err := db.CheckSchema(ctx); err != nil {
+		return fmt.Errorf("%w, run the migrate up command or set AUTO_MIGRATE", err)
+	}
+
+	// Workers get their own context, so that they are stopped only after the requests have been drained
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var workers sync.WaitGroup
+	for _, worker := range []func(context.Context){services.RunTrashPurger, services.RunFileReconciler, services.RunScanner} {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker(workerCtx)
+		}()
+	}
+
+	errs := make(chan error, 2)
+	servers := []*http.Server{newServer(config.Config.Addr, routes.SetupRoutes())}
+	serve(servers[0], "server", errs)
+
+	if config.Config.AdminAddr != "" {
+		admin := newServer(config.Config.AdminAddr, routes.SetupAdminRoutes())
+		servers = append(servers, admin)
+		serve(admin, "admin server", errs)
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+		log.Error().Err(serveErr).Msg("server failed, shutting down")
+	case <-ctx.Done():
+		log.Info().Msg("shutting down, press Ctrl+C again to force")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Config.Server.ShutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msgf("unable to drain connections of %s", server.Addr)
+		}
+	}
+
+	stopWorkers()
 
-	router := routes.SetupRoutes()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
 
-	log.Info().Msgf("server running on %s", config.Config.Addr)
-	if err := http.ListenAndServe(config.Config.Addr, router); err != nil {
-		log.Fatal().Msgf("error starting server: %v", err)
+	select {
+	case <-stopped:
+		log.Info().Msg("shutdown complete")
+	case <-shutdownCtx.Done():
+		log.Error().Msg("background workers did not stop in time")
 	}
 
+	return serveErr
 }
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func desc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+}
+
+var (
+	poolAcquiredConns   = desc("db_pool_acquired_connections", "Connections currently in use.")
+	poolIdleConns       = desc("db_pool_idle_connections", "Idle connections in the pool.")
+	poolTotalConns      = desc("db_pool_total_connections", "All connections in the pool.")
+	poolMaxConns        = desc("db_pool_max_connections", "Maximum size of the pool.")
+	poolAcquires        = desc("db_pool_acquires_total", "Successful connection acquires.")
+	poolEmptyAcquires   = desc("db_pool_empty_acquires_total", "Acquires that had to wait for a connection.")
+	poolCanceled        = desc("db_pool_canceled_acquires_total", "Acquires canceled by their context.")
+	poolAcquireDuration = desc("db_pool_acquire_duration_seconds_total", "Time spent acquiring connections.")
+)
+
+// Reports db.Pool statistics on every scrape
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolCanceled
+	ch <- poolAcquireDuration
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if db.Pool == nil {
+		return
+	}
+
+	stat := db.Pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+var (
+	cacheHits       = desc("redis_pool_hits_total", "Times a free connection was found in the pool.")
+	cacheMisses     = desc("redis_pool_misses_total", "Times a free connection was not found in the pool.")
+	cacheTimeouts   = desc("redis_pool_timeouts_total", "Times a wait for a connection timed out.")
+	cacheTotalConns = desc("redis_pool_total_connections", "All connections in the pool.")
+	cacheIdleConns  = desc("redis_pool_idle_connections", "Idle connections in the pool.")
+	cacheStaleConns = desc("redis_pool_stale_connections_total", "Stale connections removed from the pool.")
+)
+
+// Reports db.Cache connection pool statistics on every scrape
+type cacheCollector struct{}
+
+func (cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHits
+	ch <- cacheMisses
+	ch <- cacheTimeouts
+	ch <- cacheTotalConns
+	ch <- cacheIdleConns
+	ch <- cacheStaleConns
+}
+
+func (cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	if db.Cache == nil {
+		return
+	}
+
+	stats := db.Cache.PoolStats()
+	ch <- prometheus.MustNewConstMetric(cacheHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(cacheMisses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(cacheTimeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(cacheTotalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(cacheIdleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(cacheStaleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
@@ -3,24 +3,42 @@ package middleware
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/lowtierkakish/praktiline-too/config"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/db/sqlc"
 	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
 	"github.com/rs/zerolog"
 )
 
 type ctxKeyUser int
 
-const ContextUserIDKey ctxKeyUser = 0
+const (
+	ContextUserIDKey ctxKeyUser = iota
+	ContextScopesKey
+)
 
 const cookieSessionKey = "praktiline_too_session"
 
+var (
+	ErrAPITokenNotAllowed = utils.NewHTTPError(http.StatusForbidden, "api_token_not_allowed", "not allowed with api token")
+	ErrMissingScope       = utils.NewHTTPError(http.StatusForbidden, "missing_scope", "missing read scope")
+)
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := GetBearerToken(r); ok {
+			authenticateToken(next, w, r, token)
+			return
+		}
+
 		sessionID, err := GetSessionID(r)
 		if err != nil || sessionID == "" {
 			next.ServeHTTP(w, r)
@@ -51,12 +69,109 @@ func Auth(next http.Handler) http.Handler {
 	})
 }
 
+// Authenticates requests using a personal API token, the granted scopes are stored in the context
+func authenticateToken(next http.Handler, w http.ResponseWriter, r *http.Request, token string) {
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx)
+
+	userID, scopes, err := services.ValidateAPIToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidAPIToken) {
+			logger.Error().Err(err).Msg("unable to validate api token")
+		}
+		utils.JSONError(w, services.ErrInvalidAPIToken)
+		return
+	}
+
+	logger.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Int64("user", userID).Bool("api_token", true)
+	})
+
+	ctx = context.WithValue(ctx, ContextUserIDKey, userID)
+	ctx = context.WithValue(ctx, ContextScopesKey, scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 func Protect(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		userID := GetUserID(ctx)
 		if userID == 0 {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			utils.JSONError(w, utils.ErrUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Allows only requests authenticated with the session cookie, used for account management
+// which personal API tokens must not be able to do
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetScopes(r.Context()); ok {
+			utils.JSONError(w, ErrAPITokenNotAllowed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requires "<resource>:read" scope for safe methods and "<resource>:write" for everything else
+// from requests authenticated with an API token. Session requests are not restricted
+func RequireScope(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := resource + ":write"
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = resource + ":read"
+			}
+
+			if !HasScope(r.Context(), scope) {
+				utils.JSONError(w, utils.NewHTTPError(http.StatusForbidden, ErrMissingScope.Code(), "missing scope "+scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Stores the actor (user, request ID and IP) in the context for the audit log. Has to run after Auth
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx = services.WithActor(ctx, services.Actor{
+			UserID:    GetUserID(ctx),
+			RequestID: chimiddleware.GetReqID(ctx),
+			IP:        ip,
+		})
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Allows only users with the admin role
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		role, err := db.Q.GetUserRole(ctx, GetUserID(ctx))
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("unable to get user role")
+			utils.JSONError(w, utils.ErrInternal)
+			return
+		}
+
+		if role != services.RoleAdmin {
+			utils.JSONError(w, utils.ErrForbidden)
 			return
 		}
 
@@ -104,6 +219,30 @@ func GetUserID(ctx context.Context) int64 {
 	return 0
 }
 
+func GetBearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// GetScopes returns the scopes of the API token used for the request,
+// ok is false if the request was not authenticated with a token
+func GetScopes(ctx context.Context) ([]string, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	scopes, ok := ctx.Value(ContextScopesKey).([]string)
+	return scopes, ok
+}
+
+// HasScope reports whether the request is allowed the given scope, session requests have every scope
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := GetScopes(ctx)
+	return !ok || slices.Contains(scopes, scope)
+}
+
 func GetUser(ctx context.Context) (sqlc.GetUserByIDRow, error) {
 	if ctx == nil {
 		return sqlc.GetUserByIDRow{}, errors.New("context does not exist")
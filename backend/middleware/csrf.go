@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/rs/zerolog"
+)
+
+const headerCSRFToken = "X-CSRF-Token"
+
+var (
+	ErrOriginNotAllowed = utils.NewHTTPError(http.StatusForbidden, "origin_not_allowed", "origin not allowed")
+	ErrInvalidCSRFToken = utils.NewHTTPError(http.StatusForbidden, "invalid_csrf_token", "invalid csrf token")
+)
+
+// Reports whether the origin may make credentialed requests, PublicURL and ALLOWED_ORIGINS are allowed
+func OriginAllowed(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+
+	if origin == normalizeOrigin(config.Config.PublicURL) {
+		return true
+	}
+
+	for _, allowed := range config.Config.AllowedOrigins {
+		if origin == normalizeOrigin(allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeOrigin(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// CSRFToken returns the token bound to the given session
+func CSRFToken(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(config.Config.CSRFSecret))
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRF rejects state-changing requests from origins that are not allowed and requires requests
+// authenticated with the session cookie to send the session's token in the X-CSRF-Token header.
+// Has to run after Auth. Requests using API tokens are not affected since browsers never add them
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		logger := zerolog.Ctx(ctx)
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Referer()
+		}
+		if origin != "" && !OriginAllowed(origin) {
+			logger.Warn().Str("origin", origin).Msg("request from disallowed origin")
+			utils.JSONError(w, ErrOriginNotAllowed)
+			return
+		}
+
+		if _, ok := GetScopes(ctx); ok || GetUserID(ctx) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionID, err := GetSessionID(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(headerCSRFToken)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(CSRFToken(sessionID))) != 1 {
+			logger.Warn().Msg("missing or invalid CSRF token")
+			utils.JSONError(w, ErrInvalidCSRFToken)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/rs/zerolog"
+)
+
+// Attaches a request-scoped logger with the request ID, method, path and remote IP to the
+// context and logs every request once it is done. Has to run after RequestID and before Auth,
+// which adds the user ID to the same logger
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx = zerolog.Ctx(ctx).With().
+			Str("request_id", chimiddleware.GetReqID(ctx)).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("ip", ip).
+			Logger().
+			WithContext(ctx)
+
+		if slices.Contains(config.Config.AccessLog.ExcludePaths, r.URL.Path) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		start := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status < http.StatusBadRequest && !sampled(config.Config.AccessLog.SampleRate) {
+			return
+		}
+
+		// Taken from the context after the request, the fields added by Auth are only on that logger
+		logger := zerolog.Ctx(ctx)
+
+		var event *zerolog.Event
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = logger.Error()
+		case status >= http.StatusBadRequest:
+			event = logger.Warn()
+		default:
+			event = logger.Info()
+		}
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			event = event.Str("route", rctx.RoutePattern())
+		}
+
+		event.
+			Int("status", status).
+			Int("bytes", ww.BytesWritten()).
+			Dur("duration", time.Since(start)).
+			Str("user_agent", r.UserAgent()).
+			Msg("request")
+	})
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Removes the server WriteTimeout for routes that stream large responses, like file downloads,
+// which can take longer than the timeout on slow connections
+func NoWriteTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			zerolog.Ctx(r.Context()).Warn().Err(err).Msg("unable to clear write deadline")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
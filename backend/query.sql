@@ -27,6 +27,15 @@ delete from sessions where sid = $1;
 -- name: DestroyAllSessions :many
 delete from sessions where user_id = $1 returning sid;
 
+-- name: DestroyExpiredSessions :many
+delete from sessions where expires_at < now() returning sid;
+
+-- name: GetSessionsByUser :many
+select expires_at, ip, user_agent from sessions where user_id = $1 order by expires_at desc;
+
+-- name: GetSessionCreatedAt :one
+select created_at from sessions where sid = $1;
+
 -- name: UpdateSessionExpiration :exec
 update sessions set expires_at = $1 where sid = $2;
 
@@ -34,7 +43,10 @@ update sessions set expires_at = $1 where sid = $2;
 select user_id from sessions where sid = $1 and ip = $2;
 
 -- name: GetUserByID :one
-select id, first_name, last_name, email from users where id = $1;
+select id, first_name, last_name, email, role from users where id = $1;
+
+-- name: GetUserRole :one
+select role from users where id = $1;
 
 -- name: GetUserEmailByID :one
 select email from users where id = $1;
@@ -42,15 +54,91 @@ select email from users where id = $1;
 -- name: GetUserByEmail :one
 select id, password from users where email = $1;
 
+-- name: GetUserPasswordByID :one
+select password from users where id = $1;
+
+-- name: UpdateUserPassword :exec
+update users set password = $2 where id = $1;
+
+-- name: DeleteUser :exec
+delete from users where id = $1;
+
+-- name: ListUsers :many
+select id, first_name, last_name, email, role from users order by id;
+
+-- name: UpdateUserRole :exec
+update users set role = $2 where id = $1;
+
 -- name: CreateHomework :one
-insert into homework (subject, description, day, type)
-values ($1, $2, $3, $4)
-returning id, subject, description, day, type, created_at;
+insert into homework (subject, description, day, type, created_by)
+values ($1, $2, $3, $4, $5)
+returning id, subject, description, day, type, created_by, created_at;
 
 -- name: GetAllHomework :many
-select id, subject, description, day, type, created_at
+select id, subject, description, day, type, created_by, created_at
 from homework
+where deleted_at is null
 order by day asc, created_at asc;
 
--- name: DeleteHomework :exec
-delete from homework where id = $1;
+-- name: TrashHomework :one
+update homework set deleted_at = now()
+where id = $1 and deleted_at is null
+returning *;
+
+-- name: RestoreHomework :one
+update homework set deleted_at = null
+where id = $1 and deleted_at is not null
+returning *;
+
+-- name: GetTrashedHomework :many
+select * from homework
+where deleted_at is not null
+order by deleted_at desc;
+
+-- name: PurgeHomework :many
+delete from homework
+where deleted_at < sqlc.arg(deleted_before)::timestamptz
+returning *;
+
+-- name: GetHomeworkByAuthor :many
+select id, subject, description, day, type, created_by, created_at
+from homework
+where created_by = $1
+order by created_at asc;
+
+-- name: Search :many
+with search_query as (
+    select websearch_to_tsquery('estonian_simple', sqlc.arg(query)::text) as q
+), results as (
+    select 'homework'::text as type, h.id, h.subject as title, h.description as body,
+        ts_rank(setweight(to_tsvector('estonian_simple', h.subject), 'A') || setweight(to_tsvector('estonian_simple', h.description), 'B'), s.q) as rank
+    from homework h, search_query s
+    where h.deleted_at is null
+        and 'homework' = any(sqlc.arg(types)::text[])
+        and setweight(to_tsvector('estonian_simple', h.subject), 'A') || setweight(to_tsvector('estonian_simple', h.description), 'B') @@ s.q
+    union all
+    select 'material', m.id, m.name, case when m.type = 'link' then m.url else m.name end,
+        ts_rank(setweight(to_tsvector('estonian_simple', m.name), 'A') || setweight(to_tsvector('estonian_simple', case when m.type = 'link' then m.url else '' end), 'C'), s.q)
+    from materials m, search_query s
+    where m.deleted_at is null
+        and m.status = 'clean'
+        and 'material' = any(sqlc.arg(types)::text[])
+        and (
+            setweight(to_tsvector('estonian_simple', m.name), 'A') || setweight(to_tsvector('estonian_simple', case when m.type = 'link' then m.url else '' end), 'C') @@ s.q
+            or (m.type = 'link' and lower(m.url) like sqlc.arg(url_pattern)::text)
+        )
+    union all
+    select 'schedule', sc.id, sc.subject, sc.subject,
+        ts_rank(to_tsvector('estonian_simple', sc.subject), s.q)
+    from schedule sc, search_query s
+    where sc.deleted_at is null
+        and 'schedule' = any(sqlc.arg(types)::text[])
+        and to_tsvector('estonian_simple', sc.subject) @@ s.q
+    order by rank desc, id
+    limit sqlc.arg(max_results)::int
+)
+select r.type, r.id, r.title,
+    ts_headline('estonian_simple', replace(replace(replace(r.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), s.q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2') as snippet,
+    r.rank
+from results r, search_query s
+order by r.rank desc, r.id;
@@ -2,41 +2,65 @@ package routes
 
 import (
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/lowtierkakish/praktiline-too/config"
 	"github.com/lowtierkakish/praktiline-too/controllers"
+	"github.com/lowtierkakish/praktiline-too/metrics"
 	"github.com/lowtierkakish/praktiline-too/middleware"
+	"github.com/lowtierkakish/praktiline-too/services"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/rs/zerolog"
 )
 
 func SetupRoutes() *chi.Mux {
 	router := chi.NewRouter()
 
 	router.Use(chimiddleware.RequestID)
+	router.Use(middleware.AccessLog)
+	router.Use(middleware.Metrics)
 	router.Use(cors.Handler(cors.Options{
-		AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowOriginFunc:  func(r *http.Request, origin string) bool { return middleware.OriginAllowed(origin) },
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Upload-Offset"},
+		ExposedHeaders:   []string{"Link", "Retry-After", "Upload-Offset"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 	router.Use(middleware.Auth)
+	router.Use(middleware.CSRF)
+	router.Use(middleware.Actor)
 	router.Use(chimiddleware.Recoverer)
 	router.Use(chimiddleware.Heartbeat("/healthz"))
 
-	// Serve uploaded images
+	router.Get("/livez", utils.Handler(controllers.Livez))
+	router.Get("/readyz", utils.Handler(controllers.Readyz))
+
+	if config.Config.AdminAddr == "" {
+		router.Handle("/metrics", metrics.Handler())
+	}
+
+	// Serve uploaded files, dot files and directories hold unfinished uploads and are never served.
+	// Files that haven't been found clean by the scanner are not served either
 	fileServer := http.FileServer(http.Dir(config.Config.DataDir))
-	router.Handle("/uploads/*", http.StripPrefix("/uploads", fileServer))
+	router.With(middleware.NoWriteTimeout).Handle("/uploads/*", http.StripPrefix("/uploads", hideDotFiles(hideUnscannedFiles(fileServer))))
 
 	router.Route("/api", func(r chi.Router) {
 
 		// Public routes
 		r.Route("/users", func(r chi.Router) {
-			r.Post("/register", controllers.RegisterUser)
-			r.Post("/login", controllers.LoginUser)
+			r.Post("/register", utils.Handler(controllers.RegisterUser))
+			r.Post("/login", utils.Handler(controllers.LoginUser))
+			r.Post("/login/2fa", utils.Handler(controllers.LoginTwoFactor))
+		})
+
+		r.Route("/auth/oidc/{provider}", func(r chi.Router) {
+			r.Get("/start", utils.Handler(controllers.StartOIDCLogin))
+			r.Get("/callback", utils.Handler(controllers.OIDCCallback))
 		})
 
 		// protected routes
@@ -44,30 +68,123 @@ func SetupRoutes() *chi.Mux {
 			r.Use(middleware.Protect)
 
 			r.Route("/me", func(r chi.Router) {
-				r.Get("/", controllers.GetMe)
-				r.Post("/logout", controllers.Logout)
+				r.Use(middleware.SessionOnly)
+
+				r.Get("/", utils.Handler(controllers.GetMe))
+				r.Delete("/", utils.Handler(controllers.DeleteMe))
+				r.With(middleware.NoWriteTimeout).Get("/data", utils.Handler(controllers.ExportMyData))
+				r.Get("/csrf", utils.Handler(controllers.GetCSRFToken))
+				r.Put("/password", utils.Handler(controllers.ChangePassword))
+				r.Post("/logout", utils.Handler(controllers.Logout))
+
+				r.Route("/2fa", func(r chi.Router) {
+					r.Post("/setup", utils.Handler(controllers.SetupTwoFactor))
+					r.Post("/enable", utils.Handler(controllers.EnableTwoFactor))
+					r.Post("/disable", utils.Handler(controllers.DisableTwoFactor))
+				})
+
+				r.Route("/tokens", func(r chi.Router) {
+					r.Get("/", utils.Handler(controllers.GetAPITokens))
+					r.Post("/", utils.Handler(controllers.CreateAPIToken))
+					r.Delete("/{id}", utils.Handler(controllers.DeleteAPIToken))
+				})
 			})
 
 			r.Route("/homework", func(r chi.Router) {
-				r.Get("/", controllers.GetHomework)
-				r.Post("/", controllers.CreateHomework)
-				r.Delete("/{id}", controllers.DeleteHomework)
+				r.Use(middleware.RequireScope("homework"))
+
+				r.Get("/", utils.Handler(controllers.GetHomework))
+				r.Post("/", utils.Handler(controllers.CreateHomework))
+				r.Delete("/{id}", utils.Handler(controllers.DeleteHomework))
 			})
 
 			r.Route("/schedule", func(r chi.Router) {
-				r.Get("/", controllers.GetSchedule)
-				r.Post("/", controllers.CreateScheduleEntry)
-				r.Delete("/{id}", controllers.DeleteScheduleEntry)
+				r.Use(middleware.RequireScope("schedule"))
+
+				r.Get("/", utils.Handler(controllers.GetSchedule))
+				r.Post("/", utils.Handler(controllers.CreateScheduleEntry))
+				r.Delete("/{id}", utils.Handler(controllers.DeleteScheduleEntry))
+			})
+
+			r.Get("/search", utils.Handler(controllers.Search))
+
+			r.Route("/trash", func(r chi.Router) {
+				r.Use(middleware.SessionOnly)
+
+				r.Get("/", utils.Handler(controllers.GetTrash))
+				r.Post("/{type}/{id}/restore", utils.Handler(controllers.RestoreFromTrash))
+			})
+
+			r.Route("/audit", func(r chi.Router) {
+				r.Use(middleware.SessionOnly)
+				r.Use(middleware.RequireAdmin)
+
+				r.Get("/", utils.Handler(controllers.GetAuditLog))
 			})
 
 			r.Route("/materials", func(r chi.Router) {
-				r.Get("/", controllers.GetMaterials)
-				r.Post("/upload", controllers.UploadImage)
-				r.Post("/link", controllers.AddLink)
-				r.Delete("/{id}", controllers.DeleteMaterial)
+				r.Use(middleware.RequireScope("materials"))
+
+				r.Get("/", utils.Handler(controllers.GetMaterials))
+				r.Get("/usage", utils.Handler(controllers.GetStorageUsage))
+				r.Post("/upload", utils.Handler(controllers.UploadImage))
+				r.Post("/link", utils.Handler(controllers.AddLink))
+				r.Post("/uploads", utils.Handler(controllers.CreateUpload))
+				r.Get("/uploads/{id}", utils.Handler(controllers.GetUpload))
+				r.Patch("/uploads/{id}", utils.Handler(controllers.AppendUpload))
+				r.Post("/uploads/{id}/finish", utils.Handler(controllers.FinishUpload))
+				r.Delete("/uploads/{id}", utils.Handler(controllers.CancelUpload))
+				r.Post("/bulk/move", utils.Handler(controllers.MoveMaterials))
+				r.Post("/bulk/tag", utils.Handler(controllers.TagMaterials))
+				r.Post("/bulk/delete", utils.Handler(controllers.DeleteMaterials))
+				r.Get("/folders", utils.Handler(controllers.GetFolders))
+				r.Post("/folders", utils.Handler(controllers.CreateFolder))
+				r.Post("/folders/{id}/rename", utils.Handler(controllers.RenameFolder))
+				r.Post("/folders/{id}/move", utils.Handler(controllers.MoveFolder))
+				r.Delete("/folders/{id}", utils.Handler(controllers.DeleteFolder))
+				r.Delete("/{id}", utils.Handler(controllers.DeleteMaterial))
 			})
 		})
 	})
 
 	return router
 }
+
+// Routes served on AdminAddr, which should not be reachable from the internet
+func SetupAdminRoutes() *chi.Mux {
+	router := chi.NewRouter()
+
+	router.Use(chimiddleware.Recoverer)
+	router.Handle("/metrics", metrics.Handler())
+
+	return router
+}
+
+func hideDotFiles(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hideUnscannedFiles(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servable, err := services.FileIsServable(r.Context(), strings.TrimPrefix(path.Clean(r.URL.Path), "/"))
+		if err != nil {
+			zerolog.Ctx(r.Context()).Error().Err(err).Msg("unable to check scan status of file")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if !servable {
+			http.NotFound(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Size of the chunks sent to clamd, it has to be below its StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+// Clamd scans files with a ClamAV daemon listening on TCP, using the INSTREAM command
+type Clamd struct {
+	Addr string
+	// Limit for a whole scan, including connecting to the daemon
+	Timeout time.Duration
+}
+
+func NewClamd(addr string, timeout time.Duration) *Clamd {
+	return &Clamd{Addr: addr, Timeout: timeout}
+}
+
+// Streams r to clamd. The daemon replies with "stream: OK", "stream: <signature> FOUND" or a
+// message ending in ERROR. Files larger than its StreamMaxLength return ErrTooLarge
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblocks reads and writes when the context is cancelled without a deadline
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	writeErr := c.stream(conn, r)
+
+	// clamd closes the connection after replying to a stream it refuses, so the reply explains
+	// a failed write better than the write error
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (reply == "" || !errors.Is(err, io.EOF)) {
+		if writeErr != nil {
+			return Result{}, fmt.Errorf("stream to clamd: %w", writeErr)
+		}
+		return Result{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+
+	result, err := parseReply(reply)
+	if err == nil && writeErr != nil {
+		return Result{}, fmt.Errorf("stream to clamd: %w", writeErr)
+	}
+	return result, err
+}
+
+// Sends the INSTREAM command with the content in length prefixed chunks, a zero length chunk
+// ends the stream
+func (c *Clamd) stream(conn net.Conn, r io.Reader) error {
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func parseReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	status := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	case reply == "":
+		return Result{}, errors.New("clamd closed the connection without a reply")
+	case strings.Contains(status, "size limit exceeded"):
+		return Result{}, fmt.Errorf("%w, StreamMaxLength of clamd has to be raised: %s", ErrTooLarge, reply)
+	default:
+		return Result{}, fmt.Errorf("%w: %s", ErrRefused, reply)
+	}
+}
@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd is a local daemon speaking the INSTREAM protocol. It replies with reply(content) once
+// the stream ends, or with the size limit error as soon as more than maxLength bytes were sent
+type fakeClamd struct {
+	listener  net.Listener
+	maxLength int
+	reply     func(content []byte) string
+	// Content received by the last scan
+	received chan []byte
+}
+
+func newFakeClamd(t *testing.T, maxLength int, reply func(content []byte) string) *fakeClamd {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	f := &fakeClamd{listener: listener, maxLength: maxLength, reply: reply, received: make(chan []byte, 1)}
+	go f.serve()
+
+	return f
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+
+	command := make([]byte, len("zINSTREAM\x00"))
+	if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+
+	var content []byte
+	for {
+		var size uint32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(conn, chunk); err != nil {
+			return
+		}
+		content = append(content, chunk...)
+
+		if len(content) > f.maxLength {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			return
+		}
+	}
+
+	f.received <- content
+	io.WriteString(conn, f.reply(content)+"\x00")
+}
+
+func TestClamdScan(t *testing.T) {
+	eicar := []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+	reply := func(content []byte) string {
+		switch {
+		case bytes.Contains(content, []byte("EICAR")):
+			return "stream: Win.Test.EICAR_HDB-1 FOUND"
+		case bytes.Contains(content, []byte("broken")):
+			return "stream: Can't allocate memory ERROR"
+		}
+		return "stream: OK"
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    Result
+		wantErr error
+	}{
+		{name: "clean", content: []byte("hello"), want: Result{}},
+		{name: "empty", content: nil, want: Result{}},
+		{name: "larger than a chunk", content: bytes.Repeat([]byte("a"), 3*clamdChunkSize+1), want: Result{}},
+		{name: "infected", content: eicar, want: Result{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}},
+		{name: "error", content: []byte("broken"), wantErr: ErrRefused},
+		{name: "size limit", content: bytes.Repeat([]byte("a"), 5*clamdChunkSize), wantErr: ErrTooLarge},
+	}
+
+	f := newFakeClamd(t, 4*clamdChunkSize, reply)
+	clamd := NewClamd(f.listener.Addr().String(), 5*time.Second)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := clamd.Scan(context.Background(), bytes.NewReader(test.content))
+
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				// Streams refused after they ended were received, the next scan must not see them
+				select {
+				case <-f.received:
+				default:
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, result)
+			}
+			if received := <-f.received; !bytes.Equal(received, test.content) {
+				t.Errorf("daemon received %d bytes, expected %d", len(received), len(test.content))
+			}
+		})
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = NewClamd(addr, time.Second).Scan(context.Background(), strings.NewReader("hello"))
+	if err == nil || errors.Is(err, ErrRefused) {
+		t.Fatalf("expected a connection error that allows scanning again, got %v", err)
+	}
+}
+
+func TestClamdTimeout(t *testing.T) {
+	// Replies only after the client gave up
+	f := newFakeClamd(t, 1<<20, func([]byte) string {
+		time.Sleep(time.Second)
+		return "stream: OK"
+	})
+
+	_, err := NewClamd(f.listener.Addr().String(), 100*time.Millisecond).Scan(context.Background(), strings.NewReader("hello"))
+	if err == nil || errors.Is(err, ErrRefused) {
+		t.Fatalf("expected a timeout that allows scanning again, got %v", err)
+	}
+}
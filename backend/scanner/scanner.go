@@ -0,0 +1,28 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// The scanner received the file but couldn't scan it, for example because it is too large.
+// Scanning it again would fail the same way
+var ErrRefused = errors.New("scanner refused the file")
+
+// The file is larger than the scanner is configured to accept. Unlike ErrRefused this is a
+// configuration problem, the file can be scanned once the limit was raised
+var ErrTooLarge = errors.New("file is larger than the scanner accepts")
+
+// Result of scanning a file
+type Result struct {
+	Infected bool `json:"infected"`
+	// Name of the detected malware, empty when the file is clean
+	Signature string `json:"signature,omitempty"`
+}
+
+// Scanner checks the content of uploaded files. An error other than ErrRefused means the content
+// couldn't be scanned, in which case the file has to be scanned again later
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
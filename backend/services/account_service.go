@@ -0,0 +1,303 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrInvalidPassword = utils.NewHTTPError(http.StatusForbidden, "invalid_password", "invalid password")
+	ErrReauthRequired  = utils.NewHTTPError(http.StatusForbidden, "reauthentication_required", "log in again to confirm this change")
+)
+
+type UserDataExport struct {
+	ExportedAt time.Time                     `json:"exported_at"`
+	Profile    sqlc.GetUserByIDRow           `json:"profile"`
+	Identities []sqlc.UserIdentity           `json:"identities"`
+	Sessions   []sqlc.GetSessionsByUserRow   `json:"sessions"`
+	APITokens  []sqlc.APIToken               `json:"api_tokens"`
+	Homework   []sqlc.GetHomeworkByAuthorRow `json:"homework"`
+	Schedule   []sqlc.Schedule               `json:"schedule"`
+	Materials  []sqlc.Material               `json:"materials"`
+}
+
+// Collects everything stored about the given user
+func ExportUserData(ctx context.Context, userID int64) (UserDataExport, error) {
+	var export UserDataExport
+	var err error
+
+	export.ExportedAt = time.Now()
+
+	if export.Profile, err = db.Q.GetUserByID(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Identities, err = db.Q.GetUserIdentitiesByUser(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Sessions, err = db.Q.GetSessionsByUser(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.APITokens, err = db.Q.GetAPITokensByUser(ctx, userID); err != nil {
+		return export, err
+	}
+	if export.Homework, err = db.Q.GetHomeworkByAuthor(ctx, &userID); err != nil {
+		return export, err
+	}
+	if export.Schedule, err = db.Q.GetScheduleByAuthor(ctx, &userID); err != nil {
+		return export, err
+	}
+	if export.Materials, err = db.Q.GetMaterialsByAuthor(ctx, &userID); err != nil {
+		return export, err
+	}
+
+	return export, nil
+}
+
+// Data of a user ready to be written as a zip archive, with the uploaded files opened already so
+// that failures are known before anything is sent
+type UserDataArchive struct {
+	export UserDataExport
+	files  []*os.File
+}
+
+// Collects the data of the user and opens every clean file they uploaded. Missing files are skipped.
+// The archive has to be closed
+func OpenUserDataArchive(ctx context.Context, userID int64) (*UserDataArchive, error) {
+	logger := zerolog.Ctx(ctx)
+
+	export, err := ExportUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &UserDataArchive{export: export}
+	opened := make(map[string]bool)
+
+	for _, material := range export.Materials {
+		// Materials with the same content share a file, it is added once. Files that weren't found
+		// clean by the scanner are left out, data.json has the status of their material
+		if !material.HasFile() || material.Status != MaterialClean || opened[material.URL] {
+			continue
+		}
+		opened[material.URL] = true
+
+		file, err := os.Open(filepath.Join(config.Config.DataDir, material.URL))
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Warn().Int64("material", material.ID).Msg("uploaded file is missing, skipping from export")
+			continue
+		} else if err != nil {
+			a.Close()
+			return nil, err
+		}
+
+		a.files = append(a.files, file)
+	}
+
+	return a, nil
+}
+
+// Writes data.json and the uploaded files (under files/) as a zip archive
+func (a *UserDataArchive) Write(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	data, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(data)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(a.export); err != nil {
+		return err
+	}
+
+	for _, file := range a.files {
+		out, err := archive.Create("files/" + filepath.Base(file.Name()))
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(out, file); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (a *UserDataArchive) Close() {
+	for _, file := range a.files {
+		file.Close()
+	}
+}
+
+// Changes the password after confirming the current one, see reauthenticate. Users without a
+// password set one this way. All sessions of the user are destroyed, the caller is expected to
+// create a new one for the current client
+func ChangePassword(ctx context.Context, userID int64, sessionID, currentPassword, newPassword string) error {
+	if err := reauthenticate(ctx, userID, sessionID, currentPassword); err != nil {
+		return err
+	}
+
+	return replacePassword(ctx, userID, newPassword, "password_change")
+}
+
+// Confirms the identity of the user before a sensitive change. Users with a password have to
+// enter it. Users created through OIDC have none, they confirm by having logged in through their
+// provider within Session.ReauthWindow
+func reauthenticate(ctx context.Context, userID int64, sessionID, password string) error {
+	hash, err := db.Q.GetUserPasswordByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if len(hash) > 0 {
+		if err := utils.CheckPassword(hash, password); err != nil {
+			return ErrInvalidPassword
+		}
+		return nil
+	}
+
+	createdAt, err := db.Q.GetSessionCreatedAt(ctx, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrReauthRequired
+	} else if err != nil {
+		return err
+	}
+
+	if createdAt == nil || time.Since(*createdAt) > config.Config.Session.ReauthWindow {
+		return ErrReauthRequired
+	}
+
+	return nil
+}
+
+// Sets a new password without knowing the current one, used by administrators. All sessions of
+// the user are destroyed
+func ResetPassword(ctx context.Context, userID int64, newPassword string) error {
+	return replacePassword(ctx, userID, newPassword, "password_reset")
+}
+
+// Validates and stores the new password, destroys all sessions of the user and records the
+// change in the audit log with the given action
+func replacePassword(ctx context.Context, userID int64, newPassword, action string) error {
+	user, err := db.Q.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateNewPassword(ctx, newPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
+
+	newHash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if err := q.UpdateUserPassword(ctx, sqlc.UpdateUserPasswordParams{ID: userID, Password: newHash}); err != nil {
+		return err
+	}
+
+	sessions, err := q.DestroyAllSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, q, action, "user", userID, nil, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessions {
+		db.Cache.Del(ctx, SessionCacheKey(sessionID))
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Str("action", action).Msg("password was changed")
+
+	return nil
+}
+
+// Deletes the user after confirming their identity, see reauthenticate. Sessions and uploaded files no other material
+// shares are removed, homework, schedule entries and links they created stay but are no longer
+// linked to them
+func DeleteUser(ctx context.Context, userID int64, sessionID, password string) error {
+	logger := zerolog.Ctx(ctx).With().Int64("user", userID).Logger()
+
+	if err := reauthenticate(ctx, userID, sessionID, password); err != nil {
+		return err
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	sessions, err := q.DestroyAllSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	files, err := q.DeleteStoredMaterialsByAuthor(ctx, &userID)
+	if err != nil {
+		return err
+	}
+
+	var blobs blobFiles
+	for _, filename := range files {
+		if err := blobs.release(ctx, q, filename); err != nil {
+			return err
+		}
+	}
+
+	// Written before the user is removed, actor_id references the user and is set to null with it
+	if err := recordAudit(ctx, q, "delete", "user", userID, map[string]any{"files": len(files)}, nil); err != nil {
+		return err
+	}
+
+	if err := q.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	blobs.afterCommit(ctx)
+
+	for _, sessionID := range sessions {
+		db.Cache.Del(ctx, SessionCacheKey(sessionID))
+	}
+
+	logger.Info().Int("files", len(files)).Msg("user account was deleted")
+
+	return nil
+}
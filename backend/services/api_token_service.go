@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+const apiTokenPrefix = "pt_"
+
+var APITokenScopes = []string{
+	"homework:read",
+	"homework:write",
+	"schedule:read",
+	"schedule:write",
+	"materials:read",
+	"materials:write",
+}
+
+var (
+	ErrInvalidScope    = utils.NewHTTPError(http.StatusBadRequest, "invalid_scope", "invalid scope, allowed: "+strings.Join(APITokenScopes, ", "))
+	ErrInvalidAPIToken = utils.NewHTTPError(http.StatusUnauthorized, "invalid_api_token", "invalid or expired api token")
+)
+
+func hashAPIToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Creates a new token for the user and returns it in plain text together with the stored row.
+// The plain text token is not stored and can't be shown again
+func CreateAPIToken(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (string, sqlc.APIToken, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(APITokenScopes, scope) {
+			return "", sqlc.APIToken{}, ErrInvalidScope
+		}
+	}
+
+	random, err := utils.GenerateRandomStringURLSafe(32)
+	if err != nil {
+		return "", sqlc.APIToken{}, err
+	}
+	token := apiTokenPrefix + random
+
+	row, err := db.Q.CreateAPIToken(ctx, sqlc.CreateAPITokenParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", sqlc.APIToken{}, err
+	}
+
+	return token, row, nil
+}
+
+func GetAPITokens(ctx context.Context, userID int64) ([]sqlc.APIToken, error) {
+	return db.Q.GetAPITokensByUser(ctx, userID)
+}
+
+func DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	deleted, err := db.Q.DeleteAPIToken(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return utils.ErrNotFound
+	}
+	return nil
+}
+
+// Validates the given token and returns the owner's user ID and the scopes granted to the token
+func ValidateAPIToken(ctx context.Context, token string) (int64, []string, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return 0, nil, ErrInvalidAPIToken
+	}
+
+	row, err := db.Q.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil, ErrInvalidAPIToken
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	db.Q.TouchAPIToken(ctx, row.ID)
+
+	return row.UserID, row.Scopes, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+)
+
+type ctxKeyActor int
+
+const contextActorKey ctxKeyActor = 0
+
+// Actor describes who made the request, recorded with every audit log entry
+type Actor struct {
+	UserID    int64
+	RequestID string
+	IP        string
+}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, contextActorKey, actor)
+}
+
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(contextActorKey).(Actor)
+	return actor
+}
+
+// Writes an audit log entry using the given queries, so that it is stored in the same
+// transaction as the change itself. before and after are stored as JSON snapshots, nil is stored as null
+func recordAudit(ctx context.Context, q *sqlc.Queries, action, entityType string, entityID int64, before, after any) error {
+	actor := ActorFromContext(ctx)
+
+	params := sqlc.CreateAuditLogEntryParams{
+		Action:     action,
+		EntityType: entityType,
+	}
+
+	if actor.UserID != 0 {
+		params.ActorID = &actor.UserID
+	}
+	if entityID != 0 {
+		params.EntityID = &entityID
+	}
+	if actor.RequestID != "" {
+		params.RequestID = &actor.RequestID
+	}
+	if actor.IP != "" {
+		params.IP = &actor.IP
+	}
+
+	var err error
+	if before != nil {
+		if params.Before, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if params.After, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+
+	return q.CreateAuditLogEntry(ctx, params)
+}
+
+type AuditLogFilter struct {
+	Limit      int32
+	BeforeID   *int64
+	EntityType *string
+	EntityID   *int64
+}
+
+func GetAuditLog(ctx context.Context, filter AuditLogFilter) ([]sqlc.AuditLogEntry, error) {
+	return db.Q.GetAuditLog(ctx, sqlc.GetAuditLogParams(filter))
+}
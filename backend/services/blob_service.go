@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/rs/zerolog"
+)
+
+// StoredFile is a received file waiting in the temporary directory to be stored as a blob
+type StoredFile struct {
+	TempPath string
+	// Hex encoded SHA-256 of the content
+	Hash string
+	// Extension of the uploaded file name, including the dot
+	Ext  string
+	Size int64
+}
+
+// Writes src to a new temporary file while hashing it
+func ReceiveFile(src io.Reader, filename string) (StoredFile, error) {
+	if err := os.MkdirAll(UploadTempDir(), 0755); err != nil {
+		return StoredFile{}, err
+	}
+
+	out, err := os.CreateTemp(UploadTempDir(), "receive-*")
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), src)
+	// Close reports write errors that were deferred by the filesystem
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return StoredFile{}, err
+	}
+
+	return StoredFile{
+		TempPath: out.Name(),
+		Hash:     hex.EncodeToString(hash.Sum(nil)),
+		Ext:      strings.ToLower(filepath.Ext(filename)),
+		Size:     written,
+	}, nil
+}
+
+// Tracks the blob files changed by a transaction. A new blob file is moved into place before the
+// transaction commits, so that a committed blob always has its file, and is moved back when the
+// transaction fails. Files are only removed after the commit, so that a rolled back transaction
+// leaves the existing files as they were
+type blobFiles struct {
+	// Files moved into place, by file name of their blob, with the temporary file they came from
+	placed map[string]string
+	// Temporary files that weren't needed because the blob had its file already
+	duplicates []string
+	// Files whose blob lost its last reference
+	released []string
+}
+
+// Adds a reference to the blob with the content of the file and returns the file name of the blob.
+// The file is moved into place unless the blob has its file already. The blob file stays locked
+// until q's transaction ends, so that removing the file of a released blob can't race with it
+// being acquired again
+func (f *blobFiles) acquire(ctx context.Context, q *sqlc.Queries, file StoredFile) (string, error) {
+	filename, err := q.AcquireBlob(ctx, sqlc.AcquireBlobParams{
+		Hash:     file.Hash,
+		Filename: file.Hash + file.Ext,
+		Size:     file.Size,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := q.LockBlobFile(ctx, filename); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(config.Config.DataDir, filename)
+	if _, err := os.Stat(path); err == nil {
+		f.duplicates = append(f.duplicates, file.TempPath)
+		return filename, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.Rename(file.TempPath, path); err != nil {
+		return "", err
+	}
+
+	if f.placed == nil {
+		f.placed = make(map[string]string)
+	}
+	f.placed[filename] = file.TempPath
+
+	return filename, nil
+}
+
+// Removes a reference to the blob stored in the file, the file is removed by afterCommit with the
+// last reference. Files uploaded before blobs existed belong to a single material and are always
+// removed
+func (f *blobFiles) release(ctx context.Context, q *sqlc.Queries, filename string) error {
+	refCount, err := q.ReleaseBlob(ctx, filename)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if err == nil {
+		if refCount > 0 {
+			return nil
+		}
+		if err := q.DeleteBlob(ctx, filename); err != nil {
+			return err
+		}
+	}
+
+	f.released = append(f.released, filename)
+	return nil
+}
+
+// Removes the unneeded temporary files and the files of released blobs, has to be called once the
+// transaction committed. Files that can't be removed are logged and left to the file reconciler
+func (f *blobFiles) afterCommit(ctx context.Context) {
+	// The changes are committed already, they have to be completed even if the request is cancelled
+	ctx = context.WithoutCancel(ctx)
+	logger := zerolog.Ctx(ctx)
+
+	for _, tempPath := range f.duplicates {
+		if err := os.Remove(tempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error().Err(err).Str("file", tempPath).Msg("unable to remove duplicate of blob file")
+		}
+	}
+
+	for _, filename := range f.released {
+		if err := removeUnusedBlobFile(ctx, filename, ""); err != nil {
+			logger.Error().Err(err).Str("file", filename).Msg("unable to remove file of released blob")
+		}
+	}
+}
+
+// Moves the files placed by acquire back to their temporary path, has to be called when the
+// transaction failed. A file is kept when its blob was acquired by another transaction meanwhile
+func (f *blobFiles) afterRollback(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	logger := zerolog.Ctx(ctx)
+
+	for filename, tempPath := range f.placed {
+		if err := removeUnusedBlobFile(ctx, filename, tempPath); err != nil {
+			logger.Error().Err(err).Str("file", filename).Msg("unable to move back file of failed upload")
+		}
+	}
+}
+
+// Removes the file unless a blob is stored in it, which happens when it was acquired again after
+// it was released. When restoreTo is set the file is moved there instead of being removed
+func removeUnusedBlobFile(ctx context.Context, filename, restoreTo string) error {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if err := q.LockBlobFile(ctx, filename); err != nil {
+		return err
+	}
+
+	exists, err := q.BlobExists(ctx, filename)
+	if err != nil || exists {
+		return err
+	}
+
+	path := filepath.Join(config.Config.DataDir, filename)
+	if restoreTo != "" {
+		err = os.Rename(path, restoreTo)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
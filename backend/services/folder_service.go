@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+var (
+	ErrFolderNotFound = utils.NewHTTPError(http.StatusNotFound, "folder_not_found", "folder not found")
+	ErrFolderExists   = utils.NewHTTPError(http.StatusConflict, "folder_exists", "a folder with this name already exists here")
+	ErrFolderNotEmpty = utils.NewHTTPError(http.StatusConflict, "folder_not_empty", "folder has subfolders or materials")
+	ErrFolderCycle    = utils.NewHTTPError(http.StatusConflict, "folder_cycle", "a folder can't be moved into itself or its subfolders")
+)
+
+// Maps constraint violations of folder changes, 23505 is the unique name among siblings and
+// 23503 a parent that was removed in the meantime
+func folderError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrFolderNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return ErrFolderExists
+	case errors.As(err, &pgErr) && pgErr.Code == "23503":
+		return ErrFolderNotFound
+	}
+	return err
+}
+
+// Returns every folder, they are few enough for the client to build the tree
+func GetFolders(ctx context.Context) ([]sqlc.Folder, error) {
+	return db.Q.GetFolders(ctx)
+}
+
+// Creates a folder inside parentID, or at the top level when it is nil
+func CreateFolder(ctx context.Context, userID int64, parentID *int64, name string) (sqlc.Folder, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Folder{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	folder, err := q.CreateFolder(ctx, sqlc.CreateFolderParams{
+		ParentID:  parentID,
+		Name:      name,
+		CreatedBy: &userID,
+	})
+	if err != nil {
+		return folder, folderError(err)
+	}
+
+	if err := recordAudit(ctx, q, "create", "folder", folder.ID, nil, folder); err != nil {
+		return folder, err
+	}
+
+	return folder, tx.Commit(ctx)
+}
+
+func RenameFolder(ctx context.Context, id int64, name string) (sqlc.Folder, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Folder{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	before, err := q.GetFolder(ctx, id)
+	if err != nil {
+		return before, folderError(err)
+	}
+
+	folder, err := q.RenameFolder(ctx, id, name)
+	if err != nil {
+		return folder, folderError(err)
+	}
+
+	if err := recordAudit(ctx, q, "rename", "folder", folder.ID, before, folder); err != nil {
+		return folder, err
+	}
+
+	return folder, tx.Commit(ctx)
+}
+
+// Moves the folder with its contents into parentID, or to the top level when it is nil
+func MoveFolder(ctx context.Context, id int64, parentID *int64) (sqlc.Folder, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Folder{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	// Two moves checked at the same time could each pass the cycle check and create a cycle together
+	if err := q.LockFolderTree(ctx); err != nil {
+		return sqlc.Folder{}, err
+	}
+
+	before, err := q.GetFolder(ctx, id)
+	if err != nil {
+		return before, folderError(err)
+	}
+
+	if parentID != nil {
+		within, err := q.IsFolderWithin(ctx, *parentID, id)
+		if err != nil {
+			return before, err
+		}
+		if within {
+			return before, ErrFolderCycle
+		}
+	}
+
+	folder, err := q.MoveFolder(ctx, id, parentID)
+	if err != nil {
+		return folder, folderError(err)
+	}
+
+	if err := recordAudit(ctx, q, "move", "folder", folder.ID, before, folder); err != nil {
+		return folder, err
+	}
+
+	return folder, tx.Commit(ctx)
+}
+
+// Removes an empty folder. Trashed materials that were in it are restored to the top level
+func DeleteFolder(ctx context.Context, id int64) (sqlc.Folder, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Folder{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if err := q.LockFolderTree(ctx); err != nil {
+		return sqlc.Folder{}, err
+	}
+
+	empty, err := q.FolderIsEmpty(ctx, id)
+	if err != nil {
+		return sqlc.Folder{}, err
+	}
+	if !empty {
+		return sqlc.Folder{}, ErrFolderNotEmpty
+	}
+
+	folder, err := q.DeleteFolder(ctx, id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		// A subfolder was created after the check
+		return folder, ErrFolderNotEmpty
+	} else if err != nil {
+		return folder, folderError(err)
+	}
+
+	if err := recordAudit(ctx, q, "delete", "folder", folder.ID, folder, nil); err != nil {
+		return folder, err
+	}
+
+	return folder, tx.Commit(ctx)
+}
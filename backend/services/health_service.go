@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+)
+
+// How long a single readiness check may take before the dependency is considered down
+const readinessCheckTimeout = 2 * time.Second
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+type HealthCheck struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// Checks every dependency needed to serve requests: the database, the cache, the data
+// directory and the database schema. The result is ready only if all of them pass
+func CheckReadiness(ctx context.Context) (Readiness, bool) {
+	checks := map[string]func(context.Context) error{
+		"postgres":   checkPostgres,
+		"redis":      checkRedis,
+		"data_dir":   checkDataDir,
+		"migrations": checkMigrations,
+	}
+
+	readiness := Readiness{
+		Status: HealthOK,
+		Checks: make(map[string]HealthCheck, len(checks)),
+	}
+
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+		start := time.Now()
+		err := check(ctx)
+		cancel()
+
+		result := HealthCheck{Status: HealthOK, Duration: time.Since(start).String()}
+		if err != nil {
+			result.Status = HealthFail
+			result.Error = err.Error()
+			readiness.Status = HealthFail
+		}
+		readiness.Checks[name] = result
+	}
+
+	return readiness, readiness.Status == HealthOK
+}
+
+func checkPostgres(ctx context.Context) error {
+	return db.Pool.Ping(ctx)
+}
+
+func checkRedis(ctx context.Context) error {
+	return db.Cache.Ping(ctx).Err()
+}
+
+// Uploads are saved to the data directory, so it has to be writable
+func checkDataDir(ctx context.Context) error {
+	file, err := os.CreateTemp(config.Config.DataDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+
+	closeErr := file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+func checkMigrations(ctx context.Context) error {
+	return db.CheckSchema(ctx)
+}
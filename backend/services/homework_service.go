@@ -2,24 +2,132 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
 
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
 )
 
-func GetAllHomework(ctx context.Context) ([]sqlc.GetAllHomeworkRow, error) {
-	return db.Q.GetAllHomework(ctx)
+type HomeworkFilter struct {
+	Subject      string
+	Type         string
+	Day          int16
+	CreatedAfter *time.Time
 }
 
-func CreateHomework(ctx context.Context, subject, description string, day int16, hwType string) (sqlc.CreateHomeworkRow, error) {
-	return db.Q.CreateHomework(ctx, sqlc.CreateHomeworkParams{
+var homeworkSortOrders = map[string]sortOrder[sqlc.GetAllHomeworkRow]{
+	"day": {
+		columns: []sortColumn{{"day", "smallint"}, {"created_at", "timestamptz"}, {"id", "bigint"}},
+		values: func(hw sqlc.GetAllHomeworkRow) []string {
+			return []string{strconv.Itoa(int(hw.Day)), hw.CreatedAt.Format(time.RFC3339Nano), strconv.FormatInt(hw.ID, 10)}
+		},
+	},
+	"created_at": {
+		columns: []sortColumn{{"created_at", "timestamptz"}, {"id", "bigint"}},
+		values: func(hw sqlc.GetAllHomeworkRow) []string {
+			return []string{hw.CreatedAt.Format(time.RFC3339Nano), strconv.FormatInt(hw.ID, 10)}
+		},
+	},
+	"-created_at": {
+		columns: []sortColumn{{"created_at", "timestamptz"}, {"id", "bigint"}},
+		desc:    true,
+		values: func(hw sqlc.GetAllHomeworkRow) []string {
+			return []string{hw.CreatedAt.Format(time.RFC3339Nano), strconv.FormatInt(hw.ID, 10)}
+		},
+	},
+	"subject": {
+		columns: []sortColumn{{"subject", "text"}, {"id", "bigint"}},
+		values: func(hw sqlc.GetAllHomeworkRow) []string {
+			return []string{hw.Subject, strconv.FormatInt(hw.ID, 10)}
+		},
+	},
+}
+
+// Returns a page of homework matching the filter. Sort is one of day (default), created_at,
+// -created_at or subject
+func ListHomework(ctx context.Context, filter HomeworkFilter, page Page) ([]sqlc.GetAllHomeworkRow, string, error) {
+	if page.Sort == "" {
+		page.Sort = "day"
+	}
+
+	query := db.SQ.
+		Select("id", "subject", "description", "day", "type", "created_by", "created_at").
+		From("homework").
+		Where("deleted_at is null")
+
+	if filter.Subject != "" {
+		query = query.Where("lower(subject) = lower(?)", filter.Subject)
+	}
+	if filter.Type != "" {
+		query = query.Where(squirrel.Eq{"type": filter.Type})
+	}
+	if filter.Day != 0 {
+		query = query.Where(squirrel.Eq{"day": filter.Day})
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where(squirrel.Gt{"created_at": *filter.CreatedAfter})
+	}
+
+	return paginate(ctx, query, homeworkSortOrders, page, func(rows *sql.Rows) (sqlc.GetAllHomeworkRow, error) {
+		var hw sqlc.GetAllHomeworkRow
+		err := rows.Scan(&hw.ID, &hw.Subject, &hw.Description, &hw.Day, &hw.Type, &hw.CreatedBy, &hw.CreatedAt)
+		return hw, err
+	})
+}
+
+func CreateHomework(ctx context.Context, userID int64, subject, description string, day int16, hwType string) (sqlc.CreateHomeworkRow, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.CreateHomeworkRow{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	hw, err := q.CreateHomework(ctx, sqlc.CreateHomeworkParams{
 		Subject:     subject,
 		Description: description,
 		Day:         day,
 		Type:        hwType,
+		CreatedBy:   &userID,
 	})
+	if err != nil {
+		return hw, err
+	}
+
+	if err := recordAudit(ctx, q, "create", "homework", hw.ID, nil, hw); err != nil {
+		return hw, err
+	}
+
+	return hw, tx.Commit(ctx)
 }
 
+// Moves the entry to the trash, it is permanently removed after the retention period
 func DeleteHomework(ctx context.Context, id int64) error {
-	return db.Q.DeleteHomework(ctx, id)
-}
\ No newline at end of file
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	hw, err := q.TrashHomework(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return utils.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, q, "delete", "homework", hw.ID, hw, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry after %s", e.RetryAfter)
+}
+
+func loginCacheKey(kind, value string) string {
+	return "Martin's Project_:login:" + kind + ":" + value
+}
+
+func clientIP(remoteAddr string) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return ip
+}
+
+// Records a login attempt from the given address and returns *RateLimitError when the address
+// or the email is over its limit, locked out or has to wait before the next attempt
+func CheckLoginAllowed(ctx context.Context, remoteAddr, email string) error {
+	limits := config.Config.LoginLimit
+	now := time.Now()
+	email = strings.ToLower(email)
+
+	// Account lockout
+	ttl, err := db.Cache.PTTL(ctx, loginCacheKey("lockout", email)).Result()
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return &RateLimitError{RetryAfter: ttl}
+	}
+
+	// Sliding window of all attempts per IP
+	attempts, oldest, err := slidingWindowAdd(ctx, loginCacheKey("ip", clientIP(remoteAddr)), now, limits.Window)
+	if err != nil {
+		return err
+	}
+	if attempts > int64(limits.MaxPerIP) {
+		return &RateLimitError{RetryAfter: oldest.Add(limits.Window).Sub(now)}
+	}
+
+	// Progressive delay after repeated failures for the same email
+	failures, last, err := slidingWindowLast(ctx, loginCacheKey("failures", email), now, limits.Window)
+	if err != nil {
+		return err
+	}
+	if wait := loginDelay(failures) - now.Sub(last); failures > 0 && wait > 0 {
+		return &RateLimitError{RetryAfter: wait}
+	}
+
+	return nil
+}
+
+// Records a failed login for the given email and locks the account once MaxFailures is reached
+func RecordLoginFailure(ctx context.Context, remoteAddr, email string) error {
+	limits := config.Config.LoginLimit
+	now := time.Now()
+	email = strings.ToLower(email)
+
+	failures, _, err := slidingWindowAdd(ctx, loginCacheKey("failures", email), now, limits.Window)
+	if err != nil {
+		return err
+	}
+
+	if failures < int64(limits.MaxFailures) {
+		return nil
+	}
+
+	if err := db.Cache.Set(ctx, loginCacheKey("lockout", email), now.Unix(), limits.LockoutDuration).Err(); err != nil {
+		return err
+	}
+	db.Cache.Del(ctx, loginCacheKey("failures", email))
+
+	event := zerolog.Ctx(ctx).Warn().
+		Str("ip", clientIP(remoteAddr)).
+		Int64("failures", failures).
+		Dur("duration", limits.LockoutDuration)
+
+	// Lockouts of unknown emails are only logged, the audit log references existing users
+	user, err := db.Q.GetUserByEmail(ctx, email)
+	if err != nil {
+		event.Msg("unknown email temporarily locked after too many failed logins")
+		return nil
+	}
+
+	event.Int64("user", user.ID).Msg("account temporarily locked after too many failed logins")
+
+	return recordAudit(ctx, db.Q, "lockout", "user", user.ID, nil, map[string]any{
+		"failures":     failures,
+		"locked_until": time.Now().Add(limits.LockoutDuration),
+	})
+}
+
+// Clears failed attempts for the given email after a successful login
+func RecordLoginSuccess(ctx context.Context, email string) {
+	db.Cache.Del(ctx, loginCacheKey("failures", strings.ToLower(email)))
+}
+
+// Delay required between attempts after the given number of failures, doubling for every
+// failure over DelayAfter and capped at MaxDelay
+func loginDelay(failures int64) time.Duration {
+	limits := config.Config.LoginLimit
+	over := failures - int64(limits.DelayAfter)
+	if over <= 0 {
+		return 0
+	}
+
+	delay := limits.BaseDelay
+	for i := int64(1); i < over && delay < limits.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, limits.MaxDelay)
+}
+
+// Adds an entry to the sliding window stored in a sorted set and returns the number of entries
+// within the window together with the time of the oldest one
+func slidingWindowAdd(ctx context.Context, key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	var card *redis.IntCmd
+	var first *redis.ZSliceCmd
+
+	_, err := db.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+		card = pipe.ZCard(ctx, key)
+		first = pipe.ZRangeWithScores(ctx, key, 0, 0)
+		pipe.PExpire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return card.Val(), windowEntryTime(first.Val(), now), nil
+}
+
+// Returns the number of entries within the sliding window and the time of the newest one
+func slidingWindowLast(ctx context.Context, key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	var card *redis.IntCmd
+	var last *redis.ZSliceCmd
+
+	_, err := db.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		card = pipe.ZCard(ctx, key)
+		last = pipe.ZRangeWithScores(ctx, key, -1, -1)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, time.Time{}, err
+	}
+
+	return card.Val(), windowEntryTime(last.Val(), now), nil
+}
+
+func windowEntryTime(entries []redis.Z, fallback time.Time) time.Time {
+	if len(entries) == 0 {
+		return fallback
+	}
+	return time.Unix(0, int64(entries[0].Score))
+}
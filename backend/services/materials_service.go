@@ -2,23 +2,291 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
 )
 
-func GetAllMaterials(ctx context.Context) ([]sqlc.Material, error) {
-	return db.Q.GetAllMaterials(ctx)
+const maxTagLength = 50
+
+var ErrTooManyTags = utils.NewHTTPError(http.StatusBadRequest, "too_many_tags", "a material can have at most 20 tags")
+
+type MaterialFilter struct {
+	Type         string
+	CreatedAfter *time.Time
+	// Only materials directly in this folder, Unfiled selects the ones at the top level
+	FolderID *int64
+	Unfiled  bool
+	// Only materials that have all of these tags
+	Tags []string
+	// Pending and rejected materials are only listed for their uploader
+	Viewer int64
+}
+
+var materialSortOrders = map[string]sortOrder[sqlc.Material]{
+	"-created_at": {
+		columns: []sortColumn{{"created_at", "timestamptz"}, {"id", "bigint"}},
+		desc:    true,
+		values: func(m sqlc.Material) []string {
+			return []string{m.CreatedAt.Format(time.RFC3339Nano), strconv.FormatInt(m.ID, 10)}
+		},
+	},
+	"created_at": {
+		columns: []sortColumn{{"created_at", "timestamptz"}, {"id", "bigint"}},
+		values: func(m sqlc.Material) []string {
+			return []string{m.CreatedAt.Format(time.RFC3339Nano), strconv.FormatInt(m.ID, 10)}
+		},
+	},
+	"name": {
+		columns: []sortColumn{{"name", "text"}, {"id", "bigint"}},
+		values: func(m sqlc.Material) []string {
+			return []string{m.Name, strconv.FormatInt(m.ID, 10)}
+		},
+	},
+}
+
+// Returns a page of materials matching the filter. Sort is one of -created_at (default),
+// created_at or name
+func ListMaterials(ctx context.Context, filter MaterialFilter, page Page) ([]sqlc.Material, string, error) {
+	if page.Sort == "" {
+		page.Sort = "-created_at"
+	}
+
+	query := db.SQ.
+		Select("id", "name", "type", "url", "size", "status", "folder_id", "tags", "created_by", "created_at", "deleted_at").
+		From("materials").
+		Where("deleted_at is null").
+		Where(squirrel.Or{squirrel.Eq{"status": MaterialClean}, squirrel.Eq{"created_by": filter.Viewer}})
+
+	if filter.Type != "" {
+		query = query.Where(squirrel.Eq{"type": filter.Type})
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where(squirrel.Gt{"created_at": *filter.CreatedAfter})
+	}
+	if filter.FolderID != nil {
+		query = query.Where(squirrel.Eq{"folder_id": *filter.FolderID})
+	} else if filter.Unfiled {
+		query = query.Where("folder_id is null")
+	}
+	if len(filter.Tags) > 0 {
+		query = query.Where("tags @> ?", filter.Tags)
+	}
+
+	return paginate(ctx, query, materialSortOrders, page, func(rows *sql.Rows) (sqlc.Material, error) {
+		var m sqlc.Material
+		err := rows.Scan(&m.ID, &m.Name, &m.Type, &m.URL, &m.Size, &m.Status, &m.FolderID, &m.Tags, &m.CreatedBy, &m.CreatedAt, &m.DeletedAt)
+		return m, err
+	})
+}
+
+func CreateLink(ctx context.Context, userID int64, name, url string) (sqlc.Material, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	material, err := createMaterial(ctx, q, sqlc.CreateMaterialParams{
+		Name:      name,
+		Type:      "link",
+		URL:       url,
+		Status:    MaterialClean,
+		CreatedBy: &userID,
+	})
+	if err != nil {
+		return material, err
+	}
+
+	return material, tx.Commit(ctx)
+}
+
+// Stores the received file as a blob and creates a material for it. Identical files share one
+// blob, so the returned material may refer to a file uploaded earlier. The quotas are checked
+// again here, so that concurrent uploads can't exceed them together. The temporary file is
+// consumed on success. When scanning is enabled the material is pending until the file is scanned
+func CreateFileMaterial(ctx context.Context, userID int64, name, matType string, file StoredFile) (sqlc.Material, error) {
+	var files blobFiles
+
+	material, err := storeFileMaterial(ctx, &files, userID, name, matType, file)
+	if err != nil {
+		files.afterRollback(ctx)
+		return material, err
+	}
+
+	files.afterCommit(ctx)
+
+	if material.Status == MaterialPending {
+		notifyScanner()
+	}
+
+	return material, nil
+}
+
+func storeFileMaterial(ctx context.Context, files *blobFiles, userID int64, name, matType string, file StoredFile) (sqlc.Material, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if err := q.LockStorageQuota(ctx); err != nil {
+		return sqlc.Material{}, err
+	}
+	// A finished upload still holds its reservation here, which would count the file twice
+	if err := checkStorageQuota(ctx, q, userID, file.Size, reservedBytes{}); err != nil {
+		return sqlc.Material{}, err
+	}
+
+	filename, err := files.acquire(ctx, q, file)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+
+	material, err := createMaterial(ctx, q, sqlc.CreateMaterialParams{
+		Name:      name,
+		Type:      matType,
+		URL:       filename,
+		Size:      file.Size,
+		Status:    uploadStatus(),
+		CreatedBy: &userID,
+	})
+	if err != nil {
+		return material, err
+	}
+
+	return material, tx.Commit(ctx)
+}
+
+func createMaterial(ctx context.Context, q *sqlc.Queries, params sqlc.CreateMaterialParams) (sqlc.Material, error) {
+	material, err := q.CreateMaterial(ctx, params)
+	if err != nil {
+		return material, err
+	}
+
+	return material, recordAudit(ctx, q, "create", "material", material.ID, nil, material)
+}
+
+// Moves the entry to the trash, it is permanently removed after the retention period
+func DeleteMaterial(ctx context.Context, id int64) (sqlc.Material, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	material, err := q.TrashMaterial(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return material, utils.ErrNotFound
+	} else if err != nil {
+		return material, err
+	}
+
+	if err := recordAudit(ctx, q, "delete", "material", material.ID, material, nil); err != nil {
+		return material, err
+	}
+
+	return material, tx.Commit(ctx)
 }
 
-func CreateMaterial(ctx context.Context, name, matType, url string) (sqlc.Material, error) {
-	return db.Q.CreateMaterial(ctx, sqlc.CreateMaterialParams{
-		Name: name,
-		Type: matType,
-		URL:  url,
+// Lowercases and trims tags, duplicates are dropped. field is reported when a tag is invalid
+func NormalizeTags(field string, tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, utils.InvalidField(field, "notblank", "tags can't be blank")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, utils.InvalidField(field, "max", "tags can be at most 50 characters long")
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// Moves the materials into the folder, or to the top level when it is nil. Nothing is moved
+// unless all of them exist outside the trash
+func MoveMaterials(ctx context.Context, ids []int64, folderID *int64) ([]sqlc.Material, error) {
+	return bulkUpdateMaterials(ctx, ids, "move", func(q *sqlc.Queries, ids []int64) ([]sqlc.Material, error) {
+		materials, err := q.MoveMaterials(ctx, ids, folderID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return nil, ErrFolderNotFound
+		}
+		return materials, err
 	})
 }
 
-func DeleteMaterial(ctx context.Context, id int64) (sqlc.DeleteMaterialRow, error) {
-	return db.Q.DeleteMaterial(ctx, id)
+// Adds and removes tags of the materials, add and remove have to be normalized
+func TagMaterials(ctx context.Context, ids []int64, add, remove []string) ([]sqlc.Material, error) {
+	return bulkUpdateMaterials(ctx, ids, "tag", func(q *sqlc.Queries, ids []int64) ([]sqlc.Material, error) {
+		materials, err := q.TagMaterials(ctx, ids, add, remove)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23514" {
+			return nil, ErrTooManyTags
+		}
+		return materials, err
+	})
+}
+
+// Moves the materials to the trash, like DeleteMaterial
+func DeleteMaterials(ctx context.Context, ids []int64) ([]sqlc.Material, error) {
+	return bulkUpdateMaterials(ctx, ids, "delete", func(q *sqlc.Queries, ids []int64) ([]sqlc.Material, error) {
+		return q.TrashMaterials(ctx, ids)
+	})
+}
+
+// Runs a bulk update of the deduplicated IDs in one transaction and records an audit entry for
+// every material. The update is rolled back when any of the IDs wasn't updated
+func bulkUpdateMaterials(ctx context.Context, ids []int64, action string, update func(q *sqlc.Queries, ids []int64) ([]sqlc.Material, error)) ([]sqlc.Material, error) {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	materials, err := update(q, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(materials) != len(ids) {
+		return nil, utils.ErrNotFound
+	}
+
+	for _, material := range materials {
+		var before, after any = nil, material
+		if action == "delete" {
+			before, after = material, nil
+		}
+		if err := recordAudit(ctx, q, action, "material", material.ID, before, after); err != nil {
+			return nil, err
+		}
+	}
+
+	return materials, tx.Commit(ctx)
 }
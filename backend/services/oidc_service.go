@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jackc/pgx/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateDuration = 10 * time.Minute
+
+var (
+	ErrUnknownOIDCProvider  = utils.NewHTTPError(http.StatusNotFound, "unknown_oidc_provider", "unknown OIDC provider")
+	ErrInvalidOIDCState     = utils.NewHTTPError(http.StatusBadRequest, "invalid_oidc_state", "invalid or expired OIDC state")
+	ErrOIDCEmailNotVerified = utils.NewHTTPError(http.StatusForbidden, "oidc_email_not_verified", "email is not verified by the identity provider")
+)
+
+type oidcClient struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type oidcLoginState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+var (
+	oidcClients   = map[string]*oidcClient{}
+	oidcClientsMu sync.Mutex
+)
+
+func oidcStateCacheKey(state string) string {
+	return "Martin's Project_:oidc:" + state
+}
+
+// Returns the client for the provider, discovering its configuration on first use
+// so that an unreachable provider does not prevent the server from starting
+func getOIDCClient(ctx context.Context, provider string) (*oidcClient, error) {
+	cfg, ok := config.Config.OIDC.ProviderConfigs[provider]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	oidcClientsMu.Lock()
+	defer oidcClientsMu.Unlock()
+
+	if client, ok := oidcClients[provider]; ok {
+		return client, nil
+	}
+
+	discovered, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &oidcClient{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     discovered.Endpoint(),
+			RedirectURL:  strings.TrimSuffix(config.Config.OIDC.APIURL, "/") + "/api/auth/oidc/" + provider + "/callback",
+			Scopes:       cfg.Scopes,
+		},
+		verifier: discovered.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+	oidcClients[provider] = client
+
+	return client, nil
+}
+
+// Exchanges the authorization code with the PKCE verifier of the login and returns the claims of
+// the verified ID token
+func (c *oidcClient) exchange(ctx context.Context, code string, loginState oidcLoginState) (oidcClaims, error) {
+	var claims oidcClaims
+
+	token, err := c.oauth.Exchange(ctx, code, oauth2.VerifierOption(loginState.Verifier))
+	if err != nil {
+		return claims, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return claims, errors.New("token response does not contain id_token")
+	}
+
+	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return claims, err
+	}
+	if idToken.Nonce != loginState.Nonce {
+		return claims, errors.New("id_token nonce does not match")
+	}
+
+	return claims, idToken.Claims(&claims)
+}
+
+// Starts the authorization code flow with PKCE and returns the state and the URL to redirect the user to
+func StartOIDCLogin(ctx context.Context, provider string) (string, string, error) {
+	client, err := getOIDCClient(ctx, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := utils.GenerateRandomStringURLSafe(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := utils.GenerateRandomStringURLSafe(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState := oidcLoginState{
+		Provider: provider,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}
+
+	data, err := json.Marshal(loginState)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := db.Cache.Set(ctx, oidcStateCacheKey(state), data, oidcStateDuration).Err(); err != nil {
+		return "", "", err
+	}
+
+	url := client.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(loginState.Verifier))
+
+	return state, url, nil
+}
+
+// Exchanges the authorization code, verifies the ID token and returns the linked user ID,
+// linking by verified email or creating a new user when the identity is seen for the first time
+func FinishOIDCLogin(ctx context.Context, provider, state, code string) (int64, error) {
+	logger := zerolog.Ctx(ctx)
+
+	client, err := getOIDCClient(ctx, provider)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := db.Cache.GetDel(ctx, oidcStateCacheKey(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrInvalidOIDCState
+	} else if err != nil {
+		return 0, err
+	}
+
+	var loginState oidcLoginState
+	if err := json.Unmarshal(data, &loginState); err != nil {
+		return 0, err
+	}
+	if loginState.Provider != provider {
+		return 0, ErrInvalidOIDCState
+	}
+
+	claims, err := client.exchange(ctx, code, loginState)
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := db.Q.GetUserIDByIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		return userID, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return 0, ErrOIDCEmailNotVerified
+	}
+
+	email, err := utils.SanitazeEmail(claims.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	user, err := q.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		userID = user.ID
+	case errors.Is(err, pgx.ErrNoRows):
+		firstName, lastName := claims.GivenName, claims.FamilyName
+		if firstName == "" && lastName == "" {
+			firstName, lastName, _ = strings.Cut(claims.Name, " ")
+		}
+
+		// Users created through OIDC have no password and can only log in through the provider
+		userID, err = q.CreateUser(ctx, sqlc.CreateUserParams{
+			FirstName: firstName,
+			LastName:  lastName,
+			Email:     email,
+			Password:  []byte{},
+		})
+		if err != nil {
+			return 0, err
+		}
+		logger.Info().Int64("user", userID).Str("provider", provider).Msg("user was created through OIDC")
+	default:
+		return 0, err
+	}
+
+	err = q.CreateUserIdentity(ctx, sqlc.CreateUserIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    email,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	logger.Info().Int64("user", userID).Str("provider", provider).Msg("OIDC identity linked to user")
+
+	return userID, nil
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"golang.org/x/oauth2"
+)
+
+const (
+	mockOIDCProvider = "mock"
+	mockOIDCClientID = "praktiline-too"
+	mockOIDCCode     = "valid-code"
+)
+
+// mockOIDCServer is a local OIDC provider with discovery, JWKS and token endpoints. The token
+// endpoint returns an ID token built by idToken for the valid code
+type mockOIDCServer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken func(issuer string) map[string]any
+	// Key that signs the ID tokens, normally key
+	signer *rsa.PrivateKey
+	// code_verifier sent with the last token request
+	verifier string
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDCServer{key: key, signer: key}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"keys": []map[string]any{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("code") != mockOIDCCode {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		m.verifier = r.PostForm.Get("code_verifier")
+
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     signJWT(t, m.signer, m.idToken(m.URL)),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]any{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Configures the mock as the only provider and returns its discovered client
+func mockOIDCClient(t *testing.T, m *mockOIDCServer) *oidcClient {
+	t.Helper()
+
+	previous := config.Config.OIDC
+	config.Config.OIDC = &config.OIDCConfig{
+		APIURL: "http://localhost:8080",
+		ProviderConfigs: map[string]*config.OIDCProviderConfig{
+			mockOIDCProvider: {Issuer: m.URL, ClientID: mockOIDCClientID, Scopes: []string{"openid", "email"}},
+		},
+	}
+
+	t.Cleanup(func() {
+		config.Config.OIDC = previous
+		oidcClientsMu.Lock()
+		delete(oidcClients, mockOIDCProvider)
+		oidcClientsMu.Unlock()
+	})
+
+	client, err := getOIDCClient(context.Background(), mockOIDCProvider)
+	if err != nil {
+		t.Fatalf("discover mock provider: %v", err)
+	}
+
+	return client
+}
+
+func TestOIDCExchange(t *testing.T) {
+	loginState := oidcLoginState{
+		Provider: mockOIDCProvider,
+		Nonce:    "nonce",
+		Verifier: oauth2.GenerateVerifier(),
+	}
+
+	validClaims := func(issuer string) map[string]any {
+		return map[string]any{
+			"iss":            issuer,
+			"aud":            mockOIDCClientID,
+			"sub":            "subject-1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          loginState.Nonce,
+			"email":          "Mari.Maasikas@example.com",
+			"email_verified": true,
+			"given_name":     "Mari",
+			"family_name":    "Maasikas",
+		}
+	}
+
+	with := func(key string, value any) func(issuer string) map[string]any {
+		return func(issuer string) map[string]any {
+			claims := validClaims(issuer)
+			claims[key] = value
+			return claims
+		}
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		idToken func(issuer string) map[string]any
+		signer  *rsa.PrivateKey
+		wantErr string
+	}{
+		{name: "valid", code: mockOIDCCode, idToken: validClaims},
+		{name: "invalid code", code: "wrong", idToken: validClaims, wantErr: "invalid_grant"},
+		{name: "wrong nonce", code: mockOIDCCode, idToken: with("nonce", "other"), wantErr: "nonce"},
+		{name: "wrong audience", code: mockOIDCCode, idToken: with("aud", "other-client"), wantErr: "audience"},
+		{name: "wrong issuer", code: mockOIDCCode, idToken: with("iss", "https://attacker.example"), wantErr: "different provider"},
+		{name: "expired", code: mockOIDCCode, idToken: with("exp", time.Now().Add(-time.Hour).Unix()), wantErr: "expired"},
+		{name: "unknown key", code: mockOIDCCode, idToken: validClaims, signer: otherKey, wantErr: "signature"},
+	}
+
+	m := newMockOIDCServer(t)
+	client := mockOIDCClient(t, m)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m.idToken = test.idToken
+			m.signer = m.key
+			if test.signer != nil {
+				m.signer = test.signer
+			}
+
+			claims, err := client.exchange(context.Background(), test.code, loginState)
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if claims.Subject != "subject-1" || claims.Email != "Mari.Maasikas@example.com" || !claims.EmailVerified {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+			if claims.GivenName != "Mari" || claims.FamilyName != "Maasikas" {
+				t.Errorf("unexpected names %+v", claims)
+			}
+			if m.verifier != loginState.Verifier {
+				t.Errorf("token request sent code_verifier %q, expected the PKCE verifier of the login", m.verifier)
+			}
+		})
+	}
+}
+
+func TestOIDCUnknownProvider(t *testing.T) {
+	m := newMockOIDCServer(t)
+	mockOIDCClient(t, m)
+
+	if _, err := getOIDCClient(context.Background(), "unknown"); err != ErrUnknownOIDCProvider {
+		t.Fatalf("expected ErrUnknownOIDCProvider, got %v", err)
+	}
+}
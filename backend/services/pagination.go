@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var (
+	ErrInvalidCursor = utils.InvalidParameter("cursor", "invalid cursor")
+	ErrInvalidSort   = utils.NewHTTPError(http.StatusBadRequest, "invalid_sort", "invalid sort")
+)
+
+type Page struct {
+	Limit  uint64
+	Cursor string
+	Sort   string
+}
+
+type sortColumn struct {
+	name string
+	// Postgres type the cursor value is cast to when comparing
+	cast string
+}
+
+// Keyset ordering of a list. The last column has to be unique (usually id) so that the
+// position of every item is well defined
+type sortOrder[T any] struct {
+	columns []sortColumn
+	desc    bool
+	// Returns the values of the columns for the given item, in text form
+	values func(T) []string
+}
+
+type pageCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// Runs the query one page at a time ordered by the given sort, returns the items and the cursor
+// of the next page, which is empty on the last page
+func paginate[T any](ctx context.Context, query squirrel.SelectBuilder, orders map[string]sortOrder[T], page Page, scan func(*sql.Rows) (T, error)) ([]T, string, error) {
+	order, ok := orders[page.Sort]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	names := make([]string, len(order.columns))
+	placeholders := make([]string, len(order.columns))
+	orderBy := make([]string, len(order.columns))
+	for i, column := range order.columns {
+		names[i] = column.name
+		placeholders[i] = "?::" + column.cast
+		orderBy[i] = column.name + " asc"
+		if order.desc {
+			orderBy[i] = column.name + " desc"
+		}
+	}
+
+	if page.Cursor != "" {
+		args, err := decodeCursor(page.Cursor, page.Sort, order.columns)
+		if err != nil {
+			return nil, "", err
+		}
+
+		op := ">"
+		if order.desc {
+			op = "<"
+		}
+
+		query = query.Where(squirrel.Expr(
+			"("+strings.Join(names, ", ")+") "+op+" ("+strings.Join(placeholders, ", ")+")",
+			args...,
+		))
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := query.OrderBy(orderBy...).Limit(page.Limit + 1).QueryContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	items := make([]T, 0, page.Limit)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if uint64(len(items)) <= page.Limit {
+		return items, "", nil
+	}
+
+	items = items[:page.Limit]
+	next, err := encodeCursor(page.Sort, order.values(items[len(items)-1]))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, next, nil
+}
+
+func encodeCursor(sort string, values []string) (string, error) {
+	data, err := json.Marshal(pageCursor{Sort: sort, Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Cursors are only valid for the sort they were created with. The values are parsed as the
+// type of their column, so that a tampered cursor is rejected instead of failing the query
+func decodeCursor(cursor, sort string, columns []sortColumn) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Sort != sort || len(c.Values) != len(columns) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		value, err := parseCursorValue(column.cast, c.Values[i])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func parseCursorValue(cast, value string) (any, error) {
+	switch cast {
+	case "smallint":
+		return strconv.ParseInt(value, 10, 16)
+	case "bigint":
+		return strconv.ParseInt(value, 10, 64)
+	case "timestamptz":
+		return time.Parse(time.RFC3339Nano, value)
+	case "text":
+		if !utf8.ValidString(value) || strings.ContainsRune(value, 0) {
+			return nil, ErrInvalidCursor
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unsupported cursor column type %s", cast)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/rs/zerolog"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = sync.OnceValue(func() map[string]struct{} {
+	passwords := map[string]struct{}{}
+	for _, line := range strings.Split(commonPasswordsFile, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+})
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+type PasswordFeedback struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type PasswordRejectedError struct {
+	Feedback []PasswordFeedback
+}
+
+func (e *PasswordRejectedError) Error() string {
+	messages := make([]string, len(e.Feedback))
+	for i, f := range e.Feedback {
+		messages[i] = f.Message
+	}
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// Checks a new password against the length and strength requirements, the bundled list of common
+// passwords, the offline breached password dataset (if configured) and the user's own details.
+// Returns *PasswordRejectedError listing every problem found
+func ValidateNewPassword(ctx context.Context, password string, personal ...string) error {
+	cfg := config.Config.Password
+	var feedback []PasswordFeedback
+
+	if utf8.RuneCountInString(password) < cfg.MinLength {
+		feedback = append(feedback, PasswordFeedback{
+			Code:    "too_short",
+			Message: "Password must be at least " + strconv.Itoa(cfg.MinLength) + " characters long",
+		})
+	}
+
+	if EvaluatePasswordStrength(password) < PasswordStrength(cfg.MinScore) {
+		feedback = append(feedback, PasswordFeedback{
+			Code:    "too_weak",
+			Message: "Password is too weak. Use a combination of uppercase, lowercase, numbers, and symbols",
+		})
+	}
+
+	if isCommonPassword(password) {
+		feedback = append(feedback, PasswordFeedback{
+			Code:    "common",
+			Message: "Password is too common and easy to guess",
+		})
+	}
+
+	if containsPersonalInfo(password, personal) {
+		feedback = append(feedback, PasswordFeedback{
+			Code:    "personal_info",
+			Message: "Password must not contain your name or email",
+		})
+	}
+
+	breached, err := isBreachedPassword(password)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to check breached passwords dataset")
+	} else if breached {
+		feedback = append(feedback, PasswordFeedback{
+			Code:    "breached",
+			Message: "Password has appeared in a data breach, choose a different one",
+		})
+	}
+
+	if len(feedback) > 0 {
+		return &PasswordRejectedError{Feedback: feedback}
+	}
+
+	return nil
+}
+
+// Matches the password itself and its base word, e.g. "P@ssword1!" is checked as "password"
+func isCommonPassword(password string) bool {
+	passwords := commonPasswords()
+	lower := strings.ToLower(password)
+
+	for _, candidate := range []string{lower, baseWord(lower), baseWord(leetReplacer.Replace(baseWord(lower)))} {
+		if _, ok := passwords[candidate]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Strips digits and symbols around the word
+func baseWord(password string) string {
+	return strings.TrimFunc(password, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+func containsPersonalInfo(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+	for _, value := range personal {
+		value, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(value)), "@")
+		if utf8.RuneCountInString(value) >= 3 && strings.Contains(lower, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks the password up in a k-anonymity range dataset (as produced by the haveibeenpwned
+// downloader): one <first 5 hex chars of SHA-1>.txt file per prefix with "<remaining 35 chars>:<count>" lines
+func isBreachedPassword(password string) (bool, error) {
+	dir := config.Config.Password.BreachedDir
+	if dir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return count != "0", nil
+		}
+	}
+
+	return false, scanner.Err()
+}
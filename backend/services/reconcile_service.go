@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/metrics"
+	"github.com/rs/zerolog"
+)
+
+type MissingFile struct {
+	MaterialID int64  `json:"material_id"`
+	File       string `json:"file"`
+}
+
+type ReconcileResult struct {
+	// Files in the data directory that no material refers to
+	Orphans []string `json:"orphans"`
+	// How many of the orphans were removed
+	Removed int `json:"removed"`
+	// Uploaded materials whose file doesn't exist
+	Missing []MissingFile `json:"missing"`
+	// Materials uploaded before sizes were tracked whose size was filled in
+	SizesFilled int `json:"sizes_filled"`
+	// Temporary files of expired resumable uploads that were removed
+	StaleUploads int `json:"stale_uploads"`
+}
+
+// Compares the files in the data directory with the uploaded materials, including trashed ones.
+// Orphaned files older than the grace period are removed when remove is true, materials without
+// a file are only reported since they can't be fixed automatically. Missing sizes of older uploads
+// are filled in from the files. Temporary files of expired uploads are removed as well
+func ReconcileFiles(ctx context.Context, remove bool) (ReconcileResult, error) {
+	logger := zerolog.Ctx(ctx)
+	result := ReconcileResult{Orphans: []string{}, Missing: []MissingFile{}}
+
+	// Files are listed before the materials are loaded, so that a file uploaded in between is
+	// either skipped or already has its material
+	entries, err := os.ReadDir(config.Config.DataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		entries = nil
+	} else if err != nil {
+		return result, err
+	}
+
+	materials, err := db.Q.GetStoredMaterials(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	referenced := make(map[string]bool, len(materials))
+	for _, material := range materials {
+		referenced[material.URL] = true
+	}
+
+	cutoff := time.Now().Add(-config.Config.Reconcile.GracePeriod)
+	files := make(map[string]os.DirEntry, len(entries))
+
+	for _, entry := range entries {
+		// Dot files are temporary files of uploads and health checks
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files[entry.Name()] = entry
+
+		if referenced[entry.Name()] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+
+		result.Orphans = append(result.Orphans, entry.Name())
+
+		if !remove {
+			continue
+		}
+		if err := os.Remove(filepath.Join(config.Config.DataDir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error().Err(err).Str("file", entry.Name()).Msg("unable to remove orphaned file")
+			continue
+		}
+		result.Removed++
+	}
+
+	for _, material := range materials {
+		// Files of rejected materials are in quarantine
+		if material.Status == MaterialRejected {
+			continue
+		}
+
+		if entry, ok := files[material.URL]; ok {
+			if material.Size == 0 {
+				if err := fillMaterialSize(ctx, material.ID, entry); err != nil {
+					logger.Error().Err(err).Int64("material", material.ID).Msg("unable to fill in size of material")
+				} else {
+					result.SizesFilled++
+				}
+			}
+			continue
+		}
+
+		// The file may have been uploaded after the directory was listed
+		if _, err := os.Stat(filepath.Join(config.Config.DataDir, material.URL)); err == nil {
+			continue
+		}
+
+		result.Missing = append(result.Missing, MissingFile{MaterialID: material.ID, File: material.URL})
+		logger.Warn().Int64("material", material.ID).Str("file", material.URL).Msg("file of material is missing")
+	}
+
+	if remove {
+		result.StaleUploads = removeStaleUploads(ctx)
+	}
+
+	metrics.OrphanedFiles.Set(float64(len(result.Orphans) - result.Removed))
+	metrics.MissingFiles.Set(float64(len(result.Missing)))
+
+	return result, nil
+}
+
+// Removes temporary files that weren't written to for longer than uploads are kept, their state
+// has expired from the cache so they can't be resumed
+func removeStaleUploads(ctx context.Context) int {
+	logger := zerolog.Ctx(ctx)
+
+	entries, err := os.ReadDir(UploadTempDir())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error().Err(err).Msg("unable to list temporary upload files")
+		}
+		return 0
+	}
+
+	cutoff := time.Now().Add(-config.Config.Upload.Expiry)
+	removed := 0
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(UploadTempDir(), entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error().Err(err).Str("file", entry.Name()).Msg("unable to remove stale upload")
+			continue
+		}
+		removed++
+	}
+
+	return removed
+}
+
+func fillMaterialSize(ctx context.Context, id int64, entry os.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	return db.Q.SetMaterialSize(ctx, id, info.Size())
+}
+
+// Reconciles the files every Reconcile.Interval until the context is cancelled. Like the trash
+// purger, the redis lock is left to expire so that only one instance checks per interval
+func RunFileReconciler(ctx context.Context) {
+	interval := config.Config.Reconcile.Interval
+	if interval <= 0 {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("worker", "file_reconciler").Logger()
+	ctx = logger.WithContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		mutex := db.Sync.NewMutex("Martin's Project_:locks:file_reconcile", redsync.WithExpiry(interval))
+		if err := mutex.TryLockContext(ctx); err == nil {
+			result, err := ReconcileFiles(ctx, config.Config.Reconcile.RemoveOrphans)
+			if err != nil {
+				logger.Error().Err(err).Msg("unable to reconcile files")
+			} else if len(result.Orphans) > 0 || len(result.Missing) > 0 || result.SizesFilled > 0 || result.StaleUploads > 0 {
+				logger.Info().Interface("result", result).Msg("reconciled files")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
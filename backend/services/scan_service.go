@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/scanner"
+	"github.com/rs/zerolog"
+)
+
+const (
+	MaterialPending  = "pending"
+	MaterialClean    = "clean"
+	MaterialRejected = "rejected"
+)
+
+// Scanner of uploaded files, nil when scanning is disabled. Set by InitScanner, another
+// implementation can be assigned before the server starts
+var FileScanner scanner.Scanner
+
+// Wakes up the scanner when a file was uploaded, buffered so that notifying never blocks
+var scanQueue = make(chan struct{}, 1)
+
+func InitScanner() {
+	if config.Config.Scan.ClamdAddr != "" {
+		FileScanner = scanner.NewClamd(config.Config.Scan.ClamdAddr, config.Config.Scan.Timeout)
+	}
+}
+
+// Infected files are moved here, dot directories are not served and skipped by the file reconciler
+func QuarantineDir() string {
+	return filepath.Join(config.Config.DataDir, ".quarantine")
+}
+
+// Status of newly uploaded files, they are hidden from other users and not served until they
+// are scanned
+func uploadStatus() string {
+	if FileScanner == nil {
+		return MaterialClean
+	}
+	return MaterialPending
+}
+
+// Reports whether the file may be served, which is only once every material stored in it is clean
+func FileIsServable(ctx context.Context, filename string) (bool, error) {
+	unscanned, err := db.Q.FileIsUnscanned(ctx, filename)
+	return !unscanned, err
+}
+
+func notifyScanner() {
+	select {
+	case scanQueue <- struct{}{}:
+	default:
+	}
+}
+
+// Scans the oldest pending material and returns false when there was none. The material stays
+// locked during the scan, so that instances scan different files. All materials sharing the file
+// get the same status, infected files are quarantined
+func ScanNextMaterial(ctx context.Context) (bool, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	material, err := q.ClaimPendingMaterial(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	logger := zerolog.Ctx(ctx).With().Int64("material", material.ID).Str("file", material.URL).Logger()
+
+	result, err := scanFile(ctx, material.URL)
+	if errors.Is(err, scanner.ErrTooLarge) {
+		// Rejecting would discard files the configuration is wrong for. They stay pending and
+		// hidden, and are skipped for a while so that the files after them are scanned
+		logger.Error().Err(err).Msg("file is larger than SCAN_STREAM_MAX_LENGTH allows, raise StreamMaxLength in clamd.conf")
+		err = q.DeferScanByURL(ctx, material.URL, time.Now().Add(config.Config.Scan.RetryDelay))
+	} else if errors.Is(err, os.ErrNotExist) || errors.Is(err, scanner.ErrRefused) {
+		// A file that can't be scanned is never shown. Leaving it pending would block the files
+		// after it, a missing file is reported by the reconciler
+		logger.Warn().Err(err).Msg("file could not be scanned, rejecting it")
+		err = rejectMaterials(ctx, q, material.URL, scanner.Result{})
+	} else if err != nil {
+		return false, err
+	} else if result.Infected {
+		logger.Warn().Str("signature", result.Signature).Msg("infected file was quarantined")
+		err = rejectMaterials(ctx, q, material.URL, result)
+	} else {
+		_, err = q.SetStatusByURL(ctx, material.URL, MaterialClean)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+func scanFile(ctx context.Context, filename string) (scanner.Result, error) {
+	file, err := os.Open(filepath.Join(config.Config.DataDir, filename))
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	defer file.Close()
+
+	return FileScanner.Scan(ctx, file)
+}
+
+// Rejects every material stored in the file, including ones found clean with older signatures,
+// and quarantines the file before the transaction commits
+func rejectMaterials(ctx context.Context, q *sqlc.Queries, filename string, result scanner.Result) error {
+	materials, err := q.SetStatusByURL(ctx, filename, MaterialRejected)
+	if err != nil {
+		return err
+	}
+
+	for _, material := range materials {
+		if err := recordAudit(ctx, q, "reject", "material", material.ID, nil, result); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(QuarantineDir(), 0700); err != nil {
+		return err
+	}
+
+	err = os.Rename(filepath.Join(config.Config.DataDir, filename), filepath.Join(QuarantineDir(), filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Scans pending materials right after uploads and every Scan.Interval until the context is
+// cancelled. Unlike the other workers every instance scans, the pending rows are claimed with
+// skip locked so that no file is scanned twice at once
+func RunScanner(ctx context.Context) {
+	if FileScanner == nil || config.Config.Scan.Interval <= 0 {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("worker", "scanner").Logger()
+	ctx = logger.WithContext(ctx)
+
+	ticker := time.NewTicker(config.Config.Scan.Interval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			scanned, err := ScanNextMaterial(ctx)
+			if err != nil {
+				// The daemon may be down, pending files are retried on the next tick
+				logger.Error().Err(err).Msg("unable to scan file")
+				break
+			}
+			if !scanned {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-scanQueue:
+		}
+	}
+}
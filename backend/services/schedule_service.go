@@ -2,23 +2,78 @@ package services
 
 import (
 	"context"
+	"errors"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
 )
 
 func GetAllSchedule(ctx context.Context) ([]sqlc.Schedule, error) {
 	return db.Q.GetAllSchedule(ctx)
 }
 
-func CreateScheduleEntry(ctx context.Context, day, slot int16, subject string) (sqlc.Schedule, error) {
-	return db.Q.CreateScheduleEntry(ctx, sqlc.CreateScheduleParams{
-		Day:     day,
-		Slot:    slot,
-		Subject: subject,
+// Creates the entry or replaces the subject if the slot is already taken
+func CreateScheduleEntry(ctx context.Context, userID int64, day, slot int16, subject string) (sqlc.Schedule, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return sqlc.Schedule{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	var before any
+	existing, err := q.GetScheduleEntryBySlot(ctx, day, slot)
+	if err == nil {
+		before = existing
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.Schedule{}, err
+	}
+
+	entry, err := q.CreateScheduleEntry(ctx, sqlc.CreateScheduleParams{
+		Day:       day,
+		Slot:      slot,
+		Subject:   subject,
+		CreatedBy: &userID,
 	})
+	if err != nil {
+		return entry, err
+	}
+
+	action := "create"
+	if before != nil {
+		action = "update"
+	}
+
+	if err := recordAudit(ctx, q, action, "schedule", entry.ID, before, entry); err != nil {
+		return entry, err
+	}
+
+	return entry, tx.Commit(ctx)
 }
 
+// Moves the entry to the trash, it is permanently removed after the retention period
 func DeleteScheduleEntry(ctx context.Context, id int64) error {
-	return db.Q.DeleteScheduleEntry(ctx, id)
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	entry, err := q.TrashScheduleEntry(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return utils.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, q, "delete", "schedule", entry.ID, entry, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+)
+
+const (
+	DefaultSearchResults = 20
+	MaxSearchResults     = 50
+)
+
+// Searchable item types and the API token scope resource each one belongs to
+var SearchTypes = map[string]string{
+	"homework": "homework",
+	"material": "materials",
+	"schedule": "schedule",
+}
+
+// Searches homework, materials and schedule using full-text search, results are ordered by rank.
+// Snippets are HTML escaped and mark the matched words with <mark> tags
+func Search(ctx context.Context, query string, types []string, limit int32) ([]sqlc.SearchRow, error) {
+	results, err := db.Q.Search(ctx, sqlc.SearchParams{
+		Query:      query,
+		Types:      types,
+		UrlPattern: db.Pattern(query),
+		MaxResults: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if results == nil {
+		results = []sqlc.SearchRow{}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+)
+
+type StorageQuota struct {
+	Used int64 `json:"used"`
+	// Nil when there is no limit
+	Quota *int64 `json:"quota"`
+}
+
+type StorageUsage struct {
+	User  StorageQuota `json:"user"`
+	Class StorageQuota `json:"class"`
+}
+
+func GetStorageUsage(ctx context.Context, userID int64) (StorageUsage, error) {
+	usage, err := db.Q.GetStorageUsage(ctx, userID)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	return StorageUsage{
+		User:  StorageQuota{Used: usage.User, Quota: quotaLimit(config.Config.Quota.UserBytes)},
+		Class: StorageQuota{Used: usage.Total, Quota: quotaLimit(config.Config.Quota.ClassBytes)},
+	}, nil
+}
+
+// Bytes reserved by unfinished resumable uploads
+type reservedBytes struct {
+	User  int64
+	Total int64
+}
+
+// Returns utils.ErrNotEnoughStorage if storing size more bytes would exceed the quota of the user
+// or of the class, counting the sizes reserved by unfinished uploads. Used to reject uploads
+// before they are written to disk
+func CheckStorageQuota(ctx context.Context, userID, size int64) error {
+	quota := config.Config.Quota
+	if quota.UserBytes <= 0 && quota.ClassBytes <= 0 {
+		return nil
+	}
+
+	reserved, err := reservedUploadBytes(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkStorageQuota(ctx, db.Q, userID, size, reserved)
+}
+
+func checkStorageQuota(ctx context.Context, q *sqlc.Queries, userID, size int64, reserved reservedBytes) error {
+	quota := config.Config.Quota
+	if quota.UserBytes <= 0 && quota.ClassBytes <= 0 {
+		return nil
+	}
+
+	usage, err := q.GetStorageUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if quota.UserBytes > 0 && usage.User+reserved.User+size > quota.UserBytes {
+		return utils.ErrNotEnoughStorage
+	}
+	if quota.ClassBytes > 0 && usage.Total+reserved.Total+size > quota.ClassBytes {
+		return utils.ErrNotEnoughStorage
+	}
+
+	return nil
+}
+
+func quotaLimit(bytes int64) *int64 {
+	if bytes <= 0 {
+		return nil
+	}
+	return &bytes
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrUnknownTrashType = utils.NewHTTPError(http.StatusBadRequest, "unknown_trash_type", "type must be homework, schedule or materials")
+	ErrSlotTaken        = utils.NewHTTPError(http.StatusConflict, "slot_taken", "schedule slot is already taken")
+)
+
+type Trash struct {
+	Homework  []sqlc.Homework `json:"homework"`
+	Schedule  []sqlc.Schedule `json:"schedule"`
+	Materials []sqlc.Material `json:"materials"`
+}
+
+type PurgeResult struct {
+	Homework  int `json:"homework"`
+	Schedule  int `json:"schedule"`
+	Materials int `json:"materials"`
+}
+
+func GetTrash(ctx context.Context) (Trash, error) {
+	var trash Trash
+	var err error
+
+	if trash.Homework, err = db.Q.GetTrashedHomework(ctx); err != nil {
+		return trash, err
+	}
+	if trash.Schedule, err = db.Q.GetTrashedSchedule(ctx); err != nil {
+		return trash, err
+	}
+	if trash.Materials, err = db.Q.GetTrashedMaterials(ctx); err != nil {
+		return trash, err
+	}
+
+	return trash, nil
+}
+
+// Restores a trashed item, itemType is one of homework, schedule or materials
+func RestoreFromTrash(ctx context.Context, itemType string, id int64) (any, error) {
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	var restored any
+	var entityType string
+
+	switch itemType {
+	case "homework":
+		restored, err = q.RestoreHomework(ctx, id)
+		entityType = "homework"
+	case "schedule":
+		restored, err = q.RestoreScheduleEntry(ctx, id)
+		entityType = "schedule"
+	case "materials":
+		restored, err = q.RestoreMaterial(ctx, id)
+		entityType = "material"
+	default:
+		return nil, ErrUnknownTrashType
+	}
+
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, utils.ErrNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		return nil, ErrSlotTaken
+	case err != nil:
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, q, "restore", entityType, id, nil, restored); err != nil {
+		return nil, err
+	}
+
+	return restored, tx.Commit(ctx)
+}
+
+// Permanently removes items that have been in the trash for longer than the retention period,
+// together with the files of uploaded materials that no other material shares
+func PurgeTrash(ctx context.Context) (PurgeResult, error) {
+	cutoff := time.Now().Add(-config.Config.Trash.Retention)
+	var result PurgeResult
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	homework, err := q.PurgeHomework(ctx, cutoff)
+	if err != nil {
+		return result, err
+	}
+	for _, hw := range homework {
+		if err := recordAudit(ctx, q, "purge", "homework", hw.ID, hw, nil); err != nil {
+			return result, err
+		}
+	}
+
+	schedule, err := q.PurgeSchedule(ctx, cutoff)
+	if err != nil {
+		return result, err
+	}
+	for _, entry := range schedule {
+		if err := recordAudit(ctx, q, "purge", "schedule", entry.ID, entry, nil); err != nil {
+			return result, err
+		}
+	}
+
+	materials, err := q.PurgeMaterials(ctx, cutoff)
+	if err != nil {
+		return result, err
+	}
+
+	var files blobFiles
+	for _, material := range materials {
+		if err := recordAudit(ctx, q, "purge", "material", material.ID, material, nil); err != nil {
+			return result, err
+		}
+		if material.HasFile() {
+			if err := files.release(ctx, q, material.URL); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return result, err
+	}
+
+	files.afterCommit(ctx)
+
+	result = PurgeResult{
+		Homework:  len(homework),
+		Schedule:  len(schedule),
+		Materials: len(materials),
+	}
+
+	return result, nil
+}
+
+// Purges the trash every PurgeInterval until the context is cancelled. The redis lock is left
+// to expire so that only one instance purges per interval
+func RunTrashPurger(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("worker", "trash_purger").Logger()
+	ctx = logger.WithContext(ctx)
+
+	ticker := time.NewTicker(config.Config.Trash.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		mutex := db.Sync.NewMutex("Martin's Project_:locks:trash_purge", redsync.WithExpiry(config.Config.Trash.PurgeInterval))
+		if err := mutex.TryLockContext(ctx); err == nil {
+			result, err := PurgeTrash(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("unable to purge trash")
+			} else if result != (PurgeResult{}) {
+				logger.Info().Interface("purged", result).Msg("purged trash")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	totpIssuer        = "praktiline-too"
+	recoveryCodeCount = 10
+	// How long the code of a login challenge can be entered
+	LoginChallengeDuration = 5 * time.Minute
+	loginChallengeAttempts = 5
+)
+
+var (
+	ErrTwoFactorEnabled    = utils.NewHTTPError(http.StatusConflict, "two_factor_enabled", "two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp   = utils.NewHTTPError(http.StatusBadRequest, "two_factor_not_set_up", "two-factor authentication is not set up")
+	ErrInvalidTwoFactor    = utils.NewHTTPError(http.StatusBadRequest, "invalid_two_factor_code", "invalid two-factor code")
+	ErrChallengeNotFound   = utils.NewHTTPError(http.StatusUnauthorized, "challenge_not_found", "login challenge not found or expired")
+	recoveryCodeEncoding   = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeNormalizer = strings.NewReplacer("-", "", " ", "")
+)
+
+func loginChallengeCacheKey(token string) string {
+	return "Martin's Project_:2fa:" + token
+}
+
+func TwoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	totp, err := db.Q.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return totp.Enabled, nil
+}
+
+// Generates a new (not yet enabled) TOTP secret for the user and returns the otpauth URI for it
+func SetupTwoFactor(ctx context.Context, userID int64) (string, error) {
+	totp, err := db.Q.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if totp.Enabled {
+		return "", ErrTwoFactorEnabled
+	}
+
+	email, err := db.Q.GetUserEmailByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	if err := db.Q.SetUserTOTPSecret(ctx, userID, secret); err != nil {
+		return "", err
+	}
+
+	return utils.TOTPURI(totpIssuer, email, secret), nil
+}
+
+// Enables two-factor authentication after the user proves they can generate codes
+// and returns freshly generated single-use recovery codes
+func EnableTwoFactor(ctx context.Context, userID int64, code string) ([]string, error) {
+	totp, err := db.Q.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if totp.Secret == nil {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	step, ok := utils.ValidateTOTP(*totp.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := utils.GenerateRandomBytes(10)
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = raw[:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:]
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if _, err := q.UseUserTOTPStep(ctx, userID, step); err != nil {
+		return nil, err
+	}
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if err := q.CreateRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+	}
+	if err := q.EnableUserTOTP(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Msg("two-factor authentication enabled")
+
+	return codes, nil
+}
+
+// Disables two-factor authentication, requires a valid code and confirming the identity like
+// reauthenticate
+func DisableTwoFactor(ctx context.Context, userID int64, sessionID, password, code string) error {
+	if err := reauthenticate(ctx, userID, sessionID, password); err != nil {
+		return err
+	}
+
+	if err := verifyTwoFactorCode(ctx, userID, code); err != nil {
+		return err
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return err
+	}
+	if err := q.DisableUserTOTP(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Msg("two-factor authentication disabled")
+
+	return nil
+}
+
+// Creates a short-lived challenge token that has to be exchanged for a session with a valid code
+func CreateLoginChallenge(ctx context.Context, userID int64) (string, error) {
+	token, err := utils.GenerateRandomStringURLSafe(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := db.Cache.Set(ctx, loginChallengeCacheKey(token), userID, LoginChallengeDuration).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Verifies the code for the given challenge and returns the user ID. The challenge is consumed
+// on success and discarded after too many wrong codes. Wrong codes count as failed logins of the
+// user's email, so that new challenges can't be used to keep guessing, and the login only counts
+// as successful once the code was accepted. Returns *RateLimitError like CheckLoginAllowed
+func CompleteLoginChallenge(ctx context.Context, remoteAddr, token, code string) (int64, error) {
+	key := loginChallengeCacheKey(token)
+
+	val, err := db.Cache.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrChallengeNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	userID, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	email, err := db.Q.GetUserEmailByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := CheckLoginAllowed(ctx, remoteAddr, email); err != nil {
+		var limitErr *RateLimitError
+		if errors.As(err, &limitErr) {
+			return 0, err
+		}
+		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to check login rate limit")
+	}
+
+	if err := verifyTwoFactorCode(ctx, userID, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactor) {
+			if err := RecordLoginFailure(ctx, remoteAddr, email); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("unable to record failed login")
+			}
+			attempts := db.Cache.Incr(ctx, key+":attempts").Val()
+			db.Cache.Expire(ctx, key+":attempts", LoginChallengeDuration)
+			if attempts >= loginChallengeAttempts {
+				db.Cache.Del(ctx, key, key+":attempts")
+			}
+		}
+		return 0, err
+	}
+
+	db.Cache.Del(ctx, key, key+":attempts")
+	RecordLoginSuccess(ctx, email)
+
+	return userID, nil
+}
+
+// Accepts either a TOTP code or an unused recovery code
+func verifyTwoFactorCode(ctx context.Context, userID int64, code string) error {
+	totp, err := db.Q.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !totp.Enabled || totp.Secret == nil {
+		return ErrTwoFactorNotSetUp
+	}
+
+	if step, ok := utils.ValidateTOTP(*totp.Secret, code, time.Now()); ok {
+		fresh, err := db.Q.UseUserTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidTwoFactor
+		}
+		return nil
+	}
+
+	used, err := db.Q.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactor
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Msg("recovery code used")
+
+	return nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(recoveryCodeNormalizer.Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/lowtierkakish/praktiline-too/config"
+	"github.com/lowtierkakish/praktiline-too/db"
+	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/metrics"
+	"github.com/lowtierkakish/praktiline-too/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrUploadNotFound   = utils.NewHTTPError(http.StatusNotFound, "upload_not_found", "upload not found or expired")
+	ErrUploadBusy       = utils.NewHTTPError(http.StatusConflict, "upload_busy", "another request is writing to this upload")
+	ErrOffsetMismatch   = utils.NewHTTPError(http.StatusConflict, "offset_mismatch", "offset does not match the uploaded size")
+	ErrUploadIncomplete = utils.NewHTTPError(http.StatusConflict, "upload_incomplete", "upload is not complete")
+	ErrUploadTooLarge   = utils.NewHTTPError(http.StatusRequestEntityTooLarge, "upload_too_large", "upload is larger than its declared size")
+	ErrChecksumMismatch = utils.NewHTTPError(http.StatusBadRequest, "checksum_mismatch", "checksum does not match the uploaded file, the upload was discarded")
+)
+
+// A chunk has to be written before the lock expires, the server's read timeout ends requests before that
+const uploadLockExpiry = 2 * time.Minute
+
+// Material types of the file extensions accepted by resumable uploads
+var uploadFileTypes = map[string]string{
+	".jpg":  "image",
+	".jpeg": "image",
+	".png":  "image",
+	".gif":  "image",
+	".webp": "image",
+	".pdf":  "file",
+	".docx": "file",
+	".pptx": "file",
+	".xlsx": "file",
+	".odt":  "file",
+	".odp":  "file",
+	".ods":  "file",
+	".mp3":  "file",
+	".m4a":  "file",
+	".mp4":  "file",
+	".webm": "file",
+}
+
+// Upload is a resumable upload in progress. The state is kept in the cache, the received bytes
+// in a temporary file whose size is the offset
+type Upload struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func uploadCacheKey(id string) string {
+	return "Martin's Project_:uploads:" + id
+}
+
+// Set of the IDs of unfinished uploads, whose declared sizes are reserved against the quotas
+const activeUploadsKey = "Martin's Project_:active_uploads"
+
+// Temporary files of uploads, dot directories are not served and skipped by the file reconciler
+func UploadTempDir() string {
+	return filepath.Join(config.Config.DataDir, ".tmp")
+}
+
+func uploadTempPath(id string) string {
+	return filepath.Join(UploadTempDir(), id)
+}
+
+// Returns the material type for the extension of the file name
+func UploadFileType(filename string) (string, bool) {
+	matType, ok := uploadFileTypes[strings.ToLower(filepath.Ext(filename))]
+	return matType, ok
+}
+
+// Starts a resumable upload of size bytes. The quota is checked now so that the client doesn't
+// upload a file that can't be stored, and again when the upload is finished. Until then the size
+// is reserved, so that uploads started at the same time can't exceed the quota together
+func CreateUpload(ctx context.Context, userID int64, name, filename string, size int64) (Upload, error) {
+	if _, ok := UploadFileType(filename); !ok {
+		return Upload{}, utils.InvalidField("filename", "file_type", "file type is not allowed")
+	}
+	if size > config.Config.Upload.MaxBytes {
+		return Upload{}, utils.ErrRequestTooLarge
+	}
+
+	// Checking the quota and reserving the size has to happen at once
+	mutex := db.Sync.NewMutex("Martin's Project_:locks:upload_quota", redsync.WithExpiry(time.Minute))
+	if err := mutex.LockContext(ctx); err != nil {
+		return Upload{}, err
+	}
+	defer mutex.UnlockContext(context.WithoutCancel(ctx))
+
+	if err := CheckStorageQuota(ctx, userID, size); err != nil {
+		return Upload{}, err
+	}
+
+	id, err := utils.GenerateRandomStringURLSafe(16)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upload := Upload{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Filename:  filepath.Base(filename),
+		Size:      size,
+		ExpiresAt: time.Now().Add(config.Config.Upload.Expiry),
+	}
+
+	if err := os.MkdirAll(UploadTempDir(), 0755); err != nil {
+		return Upload{}, err
+	}
+
+	file, err := os.Create(uploadTempPath(id))
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := file.Close(); err != nil {
+		return Upload{}, err
+	}
+
+	if err := saveUpload(ctx, upload); err != nil {
+		removeUploadFile(ctx, uploadTempPath(id))
+		return Upload{}, err
+	}
+
+	if err := db.Cache.SAdd(ctx, activeUploadsKey, id).Err(); err != nil {
+		discardUpload(ctx, id)
+		return Upload{}, err
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Str("upload", id).Int64("size", size).Msg("upload was started")
+
+	return upload, nil
+}
+
+func GetUpload(ctx context.Context, userID int64, id string) (Upload, error) {
+	upload, err := loadUpload(ctx, userID, id)
+	if err != nil {
+		return upload, err
+	}
+
+	info, err := os.Stat(uploadTempPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return upload, ErrUploadNotFound
+	} else if err != nil {
+		return upload, err
+	}
+
+	upload.Offset = info.Size()
+	return upload, nil
+}
+
+// Appends a chunk at offset, which has to be the number of bytes received so far. Bytes written
+// before the chunk was interrupted are kept, so the client can resume from the returned offset
+func AppendUpload(ctx context.Context, userID int64, id string, offset int64, chunk io.Reader) (Upload, error) {
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer unlock()
+
+	upload, err := GetUpload(ctx, userID, id)
+	if err != nil {
+		return upload, err
+	}
+
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(uploadTempPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return upload, err
+	}
+
+	// One byte more than remaining is read to detect chunks past the declared size
+	written, err := io.Copy(file, io.LimitReader(chunk, upload.Size-upload.Offset+1))
+	if err == nil && upload.Offset+written > upload.Size {
+		err = file.Truncate(upload.Offset)
+		if err == nil {
+			err = ErrUploadTooLarge
+		}
+		written = 0
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().Add(config.Config.Upload.Expiry)
+
+	// Every chunk extends the expiry of the upload
+	if saveErr := saveUpload(ctx, upload); err == nil {
+		err = saveErr
+	}
+
+	return upload, err
+}
+
+// Verifies the SHA-256 checksum (hex encoded) of the complete upload and turns it into a material.
+// A file that doesn't match the checksum is corrupted, so the upload is discarded
+func FinishUpload(ctx context.Context, userID int64, id, checksum string) (sqlc.Material, error) {
+	logger := zerolog.Ctx(ctx).With().Int64("user", userID).Str("upload", id).Logger()
+
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+	defer unlock()
+
+	upload, err := GetUpload(ctx, userID, id)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+
+	if upload.Offset != upload.Size {
+		return sqlc.Material{}, ErrUploadIncomplete
+	}
+
+	tempPath := uploadTempPath(id)
+	sum, err := fileChecksum(tempPath)
+	if err != nil {
+		return sqlc.Material{}, err
+	}
+
+	if !strings.EqualFold(sum, checksum) {
+		logger.Warn().Str("expected", checksum).Str("actual", sum).Msg("upload checksum mismatch")
+		discardUpload(ctx, id)
+		return sqlc.Material{}, ErrChecksumMismatch
+	}
+
+	matType, _ := UploadFileType(upload.Filename)
+
+	// The checksum is the SHA-256 of the content, so it is also the hash of the blob
+	material, err := CreateFileMaterial(ctx, userID, upload.Name, matType, StoredFile{
+		TempPath: tempPath,
+		Hash:     sum,
+		Ext:      strings.ToLower(filepath.Ext(upload.Filename)),
+		Size:     upload.Size,
+	})
+	if err != nil {
+		// Finishing can be retried as long as the file is still in the temporary directory
+		if _, statErr := os.Stat(tempPath); errors.Is(statErr, os.ErrNotExist) {
+			endUpload(ctx, id)
+		}
+		return material, err
+	}
+
+	endUpload(ctx, id)
+	metrics.UploadedBytes.Add(float64(upload.Size))
+
+	logger.Info().Int64("material", material.ID).Msg("upload was finished")
+
+	return material, nil
+}
+
+func CancelUpload(ctx context.Context, userID int64, id string) error {
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := loadUpload(ctx, userID, id); err != nil {
+		return err
+	}
+
+	discardUpload(ctx, id)
+	return nil
+}
+
+// Loads the state of an upload of the given user. Uploads of other users are not found, so
+// their IDs can't be probed
+func loadUpload(ctx context.Context, userID int64, id string) (Upload, error) {
+	var upload Upload
+
+	val, err := db.Cache.Get(ctx, uploadCacheKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return upload, ErrUploadNotFound
+	} else if err != nil {
+		return upload, err
+	}
+
+	if err := json.Unmarshal(val, &upload); err != nil {
+		return upload, err
+	}
+
+	if upload.UserID != userID {
+		return Upload{}, ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+func saveUpload(ctx context.Context, upload Upload) error {
+	val, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return db.Cache.Set(ctx, uploadCacheKey(upload.ID), val, config.Config.Upload.Expiry).Err()
+}
+
+// Only one request at a time may write to an upload, otherwise chunks could be appended twice
+func lockUpload(ctx context.Context, id string) (func(), error) {
+	mutex := db.Sync.NewMutex("Martin's Project_:locks:upload:"+id, redsync.WithExpiry(uploadLockExpiry))
+	if err := mutex.TryLockContext(ctx); err != nil {
+		var taken redsync.ErrTaken
+		if errors.As(err, &taken) || errors.Is(err, redsync.ErrFailed) {
+			return nil, ErrUploadBusy
+		}
+		return nil, err
+	}
+
+	return func() {
+		// The request context may be cancelled already, the lock still has to be released
+		mutex.UnlockContext(context.WithoutCancel(ctx))
+	}, nil
+}
+
+// Removes the state of the upload and releases its reservation
+func endUpload(ctx context.Context, id string) {
+	db.Cache.Del(ctx, uploadCacheKey(id))
+	db.Cache.SRem(ctx, activeUploadsKey, id)
+}
+
+func discardUpload(ctx context.Context, id string) {
+	endUpload(ctx, id)
+	removeUploadFile(ctx, uploadTempPath(id))
+}
+
+// Returns the declared sizes of the unfinished uploads of the user and of all users. Uploads
+// that expired are removed from the set
+func reservedUploadBytes(ctx context.Context, userID int64) (reservedBytes, error) {
+	var reserved reservedBytes
+
+	ids, err := db.Cache.SMembers(ctx, activeUploadsKey).Result()
+	if err != nil || len(ids) == 0 {
+		return reserved, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = uploadCacheKey(id)
+	}
+
+	vals, err := db.Cache.MGet(ctx, keys...).Result()
+	if err != nil {
+		return reserved, err
+	}
+
+	var expired []any
+	for i, val := range vals {
+		data, ok := val.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+
+		var upload Upload
+		if err := json.Unmarshal([]byte(data), &upload); err != nil {
+			return reserved, err
+		}
+
+		reserved.Total += upload.Size
+		if upload.UserID == userID {
+			reserved.User += upload.Size
+		}
+	}
+
+	if len(expired) > 0 {
+		db.Cache.SRem(ctx, activeUploadsKey, expired...)
+	}
+
+	return reserved, nil
+}
+
+func removeUploadFile(ctx context.Context, path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		zerolog.Ctx(ctx).Error().Err(err).Str("file", path).Msg("unable to remove upload file")
+	}
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
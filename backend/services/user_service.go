@@ -12,13 +12,27 @@ import (
 	"time"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
 	"github.com/lowtierkakish/praktiline-too/config"
 	"github.com/lowtierkakish/praktiline-too/db"
 	"github.com/lowtierkakish/praktiline-too/db/sqlc"
+	"github.com/lowtierkakish/praktiline-too/metrics"
 	"github.com/lowtierkakish/praktiline-too/utils"
 	"github.com/rs/zerolog"
 )
 
+const (
+	RoleStudent = "student"
+	RoleAdmin   = "admin"
+)
+
+var (
+	ErrEmailTaken         = utils.NewHTTPError(http.StatusConflict, "email_taken", "user with this email already exists")
+	ErrInvalidCredentials = utils.NewHTTPError(http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+	ErrUserNotFound       = utils.NewHTTPError(http.StatusNotFound, "user_not_found", "user not found")
+	ErrInvalidRole        = utils.NewHTTPError(http.StatusBadRequest, "invalid_role", "role must be student or admin")
+)
+
 type UserService struct {
 	DB *sql.DB
 }
@@ -32,7 +46,7 @@ func CreateUser(ctx context.Context, first_name, last_name, email, password stri
 		logger.Error().Err(err).Msgf("unable to check if user with %s mail exists", email)
 		return 0, err
 	} else if userExists {
-		return 0, errors.New("user with this email already exists")
+		return 0, ErrEmailTaken
 	}
 
 	hashedPassword, err := utils.HashPassword(password)
@@ -68,11 +82,14 @@ func ValidateSession(ctx context.Context, remoteAddr, sessionID string) (bool, i
 	// First, let's check Cache for user session (it's faster than looking up in DB)
 	if val, err := db.Cache.Get(ctx, SessionCacheKey(sessionID)).Result(); err == nil {
 		if parts := strings.Split(val, "|"); len(parts) >= 1 {
+			metrics.SessionCache.WithLabelValues("hit").Inc()
 			id, err := strconv.ParseInt(parts[0], 10, 64)
 			return false, id, err
 		}
 	}
 
+	metrics.SessionCache.WithLabelValues("miss").Inc()
+
 	// Session does not exist in Cache, look up in DB
 	userID, err := db.Q.GetUserIDBySession(ctx, sessionID)
 	if err != nil {
@@ -178,17 +195,45 @@ func Authenticate(ctx context.Context, email, password string) (int64, error) {
 	email = strings.ToLower(email)
 
 	user, err := db.Q.GetUserByEmail(ctx, email)
-	if err != nil {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
 		return 0, err
 	}
 
 	if err := utils.CheckPassword(user.Password, password); err != nil {
-		return 0, errors.New("invalid password")
+		return 0, ErrInvalidCredentials
+	}
+
+	if utils.PasswordNeedsRehash(user.Password) {
+		rehashPassword(ctx, user.ID, password)
 	}
 
 	return user.ID, nil
 }
 
+// Replaces an outdated password hash, failures are only logged since the login itself succeeded
+func rehashPassword(ctx context.Context, userID int64, password string) {
+	logger := zerolog.Ctx(ctx).With().Int64("user", userID).Logger()
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to rehash password")
+		return
+	}
+
+	err = db.Q.UpdateUserPassword(ctx, sqlc.UpdateUserPasswordParams{
+		ID:       userID,
+		Password: hash,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to store rehashed password")
+		return
+	}
+
+	logger.Info().Msg("password hash was upgraded")
+}
+
 func UserWithEmailExists(ctx context.Context, email string) (bool, error) {
 	var exists bool
 	err := db.SQ.Select("1").Prefix("SELECT EXISTS (").From("users").Where(squirrel.Eq{"email": email}).Suffix(")").ScanContext(ctx, &exists)
@@ -204,3 +249,81 @@ func DestroySession(ctx context.Context, sessionID string) error {
 
 	return nil
 }
+
+// Destroys all sessions of the user and returns how many there were
+func DestroyUserSessions(ctx context.Context, userID int64) (int, error) {
+	sessions, err := db.Q.DestroyAllSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, sessionID := range sessions {
+		db.Cache.Del(ctx, SessionCacheKey(sessionID))
+	}
+
+	return len(sessions), nil
+}
+
+// Removes expired sessions and returns how many were removed
+func PurgeExpiredSessions(ctx context.Context) (int, error) {
+	sessions, err := db.Q.DestroyExpiredSessions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, sessionID := range sessions {
+		db.Cache.Del(ctx, SessionCacheKey(sessionID))
+	}
+
+	return len(sessions), nil
+}
+
+func ListUsers(ctx context.Context) ([]sqlc.ListUsersRow, error) {
+	return db.Q.ListUsers(ctx)
+}
+
+func UserIDByEmail(ctx context.Context, email string) (int64, error) {
+	user, err := db.Q.GetUserByEmail(ctx, strings.ToLower(email))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+
+	return user.ID, err
+}
+
+func SetUserRole(ctx context.Context, userID int64, role string) error {
+	if role != RoleStudent && role != RoleAdmin {
+		return ErrInvalidRole
+	}
+
+	tx, err := db.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := db.Q.WithTx(tx)
+
+	before, err := q.GetUserRole(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := q.UpdateUserRole(ctx, sqlc.UpdateUserRoleParams{ID: userID, Role: role}); err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, q, "role_change", "user", userID, utils.H{"role": before}, utils.H{"role": role}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().Int64("user", userID).Str("role", role).Msg("user role was changed")
+
+	return nil
+}
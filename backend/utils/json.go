@@ -6,80 +6,289 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
 	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
 )
 
+// HTTPError is an error with the status and the machine-readable code sent to the client.
+// Services return these for errors the client can act on, everything else is a 500
 type HTTPError struct {
 	status  int
+	code    string
 	message string
+	fields  []FieldError
+	header  http.Header
+}
+
+// FieldError describes why a single field of the request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func (e *HTTPError) Error() string {
 	return e.message
 }
 
-func NewHTTPError(status int, message string) *HTTPError {
-	return &HTTPError{status: status, message: message}
+// Errors are equal when their codes are, so that copies made by WithFields and WithHeader
+// still match the original with errors.Is
+func (e *HTTPError) Is(target error) bool {
+	t, ok := target.(*HTTPError)
+	return ok && t.code == e.code
+}
+
+func (e *HTTPError) Status() int {
+	return e.status
+}
+
+func (e *HTTPError) Code() string {
+	return e.code
+}
+
+// Returns a copy of the error with the given field errors
+func (e *HTTPError) WithFields(fields ...FieldError) *HTTPError {
+	c := *e
+	c.fields = append(append([]FieldError(nil), e.fields...), fields...)
+	return &c
+}
+
+// Returns a copy of the error that sets the given header on the response
+func (e *HTTPError) WithHeader(key, value string) *HTTPError {
+	c := *e
+	c.header = e.header.Clone()
+	if c.header == nil {
+		c.header = http.Header{}
+	}
+	c.header.Set(key, value)
+	return &c
+}
+
+func NewHTTPError(status int, code, message string) *HTTPError {
+	return &HTTPError{status: status, code: code, message: message}
 }
 
 var (
-	ErrNotFound         = NewHTTPError(http.StatusNotFound, "not found")
-	ErrNotEnoughStorage = NewHTTPError(http.StatusForbidden, "not enough storage")
-	ErrDatabaseError    = NewHTTPError(http.StatusInternalServerError, "database error")
-	ErrFilesystemError  = NewHTTPError(http.StatusInternalServerError, "filesystem error")
-	ErrPaymentError     = NewHTTPError(http.StatusInternalServerError, "payment server error")
+	ErrBadRequest       = NewHTTPError(http.StatusBadRequest, "bad_request", "bad request")
+	ErrInvalidJSON      = NewHTTPError(http.StatusBadRequest, "invalid_json", "invalid request format")
+	ErrValidation       = NewHTTPError(http.StatusBadRequest, "validation_failed", "invalid request")
+	ErrInvalidParameter = NewHTTPError(http.StatusBadRequest, "invalid_parameter", "invalid parameter")
+	ErrUnauthorized     = NewHTTPError(http.StatusUnauthorized, "unauthorized", "unauthorized")
+	ErrForbidden        = NewHTTPError(http.StatusForbidden, "forbidden", "forbidden")
+	ErrNotFound         = NewHTTPError(http.StatusNotFound, "not_found", "not found")
+	ErrRequestTooLarge  = NewHTTPError(http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+	ErrNotEnoughStorage = NewHTTPError(http.StatusForbidden, "not_enough_storage", "not enough storage")
+	ErrInternal         = NewHTTPError(http.StatusInternalServerError, "internal_error", "internal server error")
+	ErrDatabaseError    = NewHTTPError(http.StatusInternalServerError, "database_error", "database error")
+	ErrFilesystemError  = NewHTTPError(http.StatusInternalServerError, "filesystem_error", "filesystem error")
+	ErrPaymentError     = NewHTTPError(http.StatusInternalServerError, "payment_error", "payment server error")
 )
 
+// Rejects a query or path parameter, the message is also used as the error message
+func InvalidParameter(name, message string) *HTTPError {
+	err := ErrInvalidParameter.WithFields(FieldError{Field: name, Code: "invalid", Message: message})
+	err.message = message
+	return err
+}
+
+// Rejects a field of the request body that can't be checked with validate tags
+func InvalidField(field, code, message string) *HTTPError {
+	err := ErrValidation.WithFields(FieldError{Field: field, Code: code, Message: message})
+	err.message = message
+	return err
+}
+
 var Validate *validator.Validate
 
 type H map[string]any
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+
+	// Field errors use the JSON names of the fields
+	Validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	if err := Validate.RegisterValidation("notblank", validators.NotBlank); err != nil {
+		panic(err)
+	}
 }
 
-func JSONErrorMessage(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(H{"error": message})
+// HandlerFunc is an HTTP handler that returns its error instead of writing it
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+type responseRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
 }
 
-func JSONError(w http.ResponseWriter, err error) {
-	if err == nil {
-		return
+func (r *responseRecorder) WriteHeader(status int) {
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Adapts a HandlerFunc to http.HandlerFunc. Returned errors are written with JSONError and
+// logged when they are server errors. Errors returned after the response has been started
+// can only be logged
+func Handler(h HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+
+		err := h(rec, r)
+		if err == nil {
+			return
+		}
+
+		httpErr := AsHTTPError(err)
+		if httpErr.status >= http.StatusInternalServerError || rec.wroteHeader {
+			zerolog.Ctx(r.Context()).Error().Err(err).Msg("request failed")
+		}
+
+		if rec.wroteHeader {
+			return
+		}
+
+		w.Header().Del("Content-Disposition")
+		JSONError(w, err)
+	}
+}
+
+// Converts any error to the HTTPError sent to the client. Unknown errors become ErrInternal
+// so that their messages are never exposed
+func AsHTTPError(err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
 	}
 
 	if errors.Is(err, pgx.ErrNoRows) {
-		JSONErrorMessage(w, "not found", http.StatusNotFound)
-		return
+		return ErrNotFound
 	}
 
-	if err, ok := err.(validator.ValidationErrors); ok {
-		JSONErrorMessage(w, err.Error(), http.StatusBadRequest)
-		return
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, len(validationErrs))
+		for i, fieldErr := range validationErrs {
+			fields[i] = FieldError{
+				Field:   fieldErr.Field(),
+				Code:    fieldErr.Tag(),
+				Message: validationMessage(fieldErr),
+			}
+		}
+
+		httpErr := ErrValidation.WithFields(fields...)
+		httpErr.message = fields[0].Message
+		return httpErr
 	}
 
-	if err, ok := err.(*json.SyntaxError); ok {
-		JSONErrorMessage(w, err.Error(), http.StatusBadRequest)
-		return
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return ErrInvalidJSON
 	}
-	if err, ok := err.(*json.UnmarshalTypeError); ok {
-		if err.Field == "" {
-			err.Field = ":root"
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := typeErr.Field
+		if field == "" {
+			field = ":root"
 		}
 
-		JSONErrorMessage(w, fmt.Sprintf("expected %s but got %s in %s", err.Type, err.Value, err.Field), http.StatusBadRequest)
-		return
+		httpErr := ErrInvalidJSON.WithFields(FieldError{
+			Field:   field,
+			Code:    "type",
+			Message: fmt.Sprintf("expected %s but got %s", typeErr.Type, typeErr.Value),
+		})
+		httpErr.message = fmt.Sprintf("expected %s but got %s in %s", typeErr.Type, typeErr.Value, field)
+		return httpErr
 	}
 
-	if err, ok := err.(*HTTPError); ok {
-		JSONErrorMessage(w, err.message, err.status)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrRequestTooLarge
+	}
+
+	return ErrInternal
+}
+
+func validationMessage(err validator.FieldError) string {
+	field := err.Field()
+	kind := err.Kind()
+
+	unit := ""
+	switch kind {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		unit = " items"
+	}
+
+	switch err.Tag() {
+	case "required", "notblank":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	case "url", "http_url":
+		return field + " must be a valid URL"
+	case "min", "gte":
+		if unit == " items" && err.Param() == "1" {
+			return field + " must not be empty"
+		}
+		return field + " must be at least " + err.Param() + unit
+	case "max", "lte":
+		return field + " must be at most " + err.Param() + unit
+	case "len":
+		return field + " must be exactly " + err.Param() + unit
+	case "oneof":
+		return field + " must be one of " + strings.Join(strings.Fields(err.Param()), ", ")
+	default:
+		return field + " is invalid"
+	}
+}
+
+// Writes the error as {"error": message, "code": code, "fields": [...]}
+func JSONError(w http.ResponseWriter, err error) {
+	if err == nil {
 		return
 	}
 
-	JSONErrorMessage(w, err.Error(), 500)
+	httpErr := AsHTTPError(err)
+
+	for key, values := range httpErr.header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	body := H{
+		"error": httpErr.message,
+		"code":  httpErr.code,
+	}
+	if len(httpErr.fields) > 0 {
+		body["fields"] = httpErr.fields
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpErr.status)
+	json.NewEncoder(w).Encode(body)
 }
 
 func JSONResponse(w http.ResponseWriter, v any) error {
@@ -88,18 +297,19 @@ func JSONResponse(w http.ResponseWriter, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
+// Decodes the request body into v and validates it using the validate struct tags
 func JSONBody(r *http.Request, v any) error {
 	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
 		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
-			return NewHTTPError(http.StatusBadRequest, "unexpected end of body")
+			return ErrInvalidJSON
 		}
 
 		return err
 	}
 
-	if err := Validate.Var(v, "required,dive"); err != nil {
-		return err
+	if reflect.Indirect(reflect.ValueOf(v)).Kind() != reflect.Struct {
+		return nil
 	}
 
-	return nil
+	return Validate.Struct(v)
 }
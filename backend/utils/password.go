@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lowtierkakish/praktiline-too/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch   = errors.New("password does not match")
+	ErrUnknownHashFormat  = errors.New("unknown password hash format")
+	ErrInvalidHashEncoded = errors.New("invalid encoded password hash")
+)
+
+// PasswordHasher hashes passwords into a self-describing encoded form that contains
+// the algorithm and its parameters, so that hashes made with older settings can still be verified
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Verify(hash []byte, password string) error
+	// Matches reports whether the encoded hash was produced by this algorithm
+	Matches(hash []byte) bool
+	// NeedsRehash reports whether the hash was made with weaker parameters than the current ones
+	NeedsRehash(hash []byte) bool
+}
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var b64 = base64.RawStdEncoding
+
+func (h *Argon2idHasher) Hash(password string) ([]byte, error) {
+	salt, err := GenerateRandomBytes(int(h.SaltLength))
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (h *Argon2idHasher) Verify(hash []byte, password string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *Argon2idHasher) Matches(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) < h.SaltLength ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func decodeArgon2id(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	// argon2.IDKey panics on parameters below its minimums, a corrupted hash must not crash the request
+	if params.Iterations < 1 || params.Parallelism < 1 || params.Memory < 8*uint32(params.Parallelism) {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHashEncoded
+	}
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher is kept for verifying hashes created before Argon2id became the default.
+// Bcrypt only uses the first 72 bytes of the password
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+}
+
+func (h *BcryptHasher) Verify(hash []byte, password string) error {
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *BcryptHasher) Matches(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h *BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < h.Cost
+}
+
+// DefaultPasswordHasher returns the hasher used for new passwords, configured from PASSWORD_* variables
+func DefaultPasswordHasher() PasswordHasher {
+	hasher := &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+
+	if cfg := config.Config.Password; cfg != nil {
+		hasher.Memory = cfg.Argon2Memory
+		hasher.Iterations = cfg.Argon2Iterations
+		hasher.Parallelism = cfg.Argon2Parallelism
+	}
+
+	return hasher
+}
+
+// Hashers that are still accepted when verifying passwords
+func passwordHashers() []PasswordHasher {
+	return []PasswordHasher{
+		DefaultPasswordHasher(),
+		&BcryptHasher{Cost: bcrypt.DefaultCost},
+	}
+}
+
+func CheckPassword(hash []byte, password string) error {
+	for _, hasher := range passwordHashers() {
+		if hasher.Matches(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return ErrUnknownHashFormat
+}
+
+func HashPassword(password string) ([]byte, error) {
+	return DefaultPasswordHasher().Hash(password)
+}
+
+// PasswordNeedsRehash reports whether the hash should be replaced with one made by the default hasher
+func PasswordNeedsRehash(hash []byte) bool {
+	hasher := DefaultPasswordHasher()
+	return !hasher.Matches(hash) || hasher.NeedsRehash(hash)
+}
@@ -1,4 +1,14 @@
-import { apiClient } from "@/lib/api"
+import { apiClient, clearCsrfToken, fetchCsrfToken } from "@/lib/api"
+
+// Fetches the token of the new session, requests fall back to fetching it on demand
+async function refreshCsrfToken() {
+    clearCsrfToken()
+    try {
+        await fetchCsrfToken()
+    } catch (error) {
+        console.error("Unable to fetch CSRF token:", error)
+    }
+}
 
 export const authApi = {
     login: async (email: string, password: string) => {
@@ -7,6 +17,23 @@ export const authApi = {
                 email,
                 password,
             })
+            // Users with two-factor authentication get a session only after the code
+            if (!response.data?.two_factor_required) {
+                await refreshCsrfToken()
+            }
+            return response.data
+        } catch (error) {
+            throw error
+        }
+    },
+
+    loginTwoFactor: async (challenge: string, code: string) => {
+        try {
+            const response = await apiClient.post("/users/login/2fa", {
+                challenge,
+                code,
+            })
+            await refreshCsrfToken()
             return response.data
         } catch (error) {
             throw error
@@ -21,6 +48,7 @@ export const authApi = {
                 email,
                 password,
             })
+            await refreshCsrfToken()
             return response.data
         } catch (error) {
             throw error
@@ -39,6 +67,7 @@ export const authApi = {
     logout: async () => {
         try {
             const response = await apiClient.post("/me/logout")
+            clearCsrfToken()
             return response.data
         } catch (error) {
             throw error
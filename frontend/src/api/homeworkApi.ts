@@ -1,4 +1,4 @@
-import { apiClient } from "@/lib/api"
+import { apiClient, getAllPages } from "@/lib/api"
 
 export type Homework = {
     id: number
@@ -10,10 +10,7 @@ export type Homework = {
 }
 
 export const homeworkApi = {
-    getAll: async (): Promise<Homework[]> => {
-        const res = await apiClient.get("/homework/")
-        return res.data
-    },
+    getAll: (): Promise<Homework[]> => getAllPages<Homework>("/homework/", { limit: 200 }),
     create: async (data: { subject: string; description: string; day: number; type: string }): Promise<Homework> => {
         const res = await apiClient.post("/homework/", data)
         return res.data
@@ -1,4 +1,4 @@
-import { apiClient } from "@/lib/api"
+import { apiClient, getAllPages } from "@/lib/api"
 
 export type Material = {
     id: number
@@ -11,10 +11,7 @@ export type Material = {
 const BASE_URL = process.env.NEXT_PUBLIC_API_URL?.replace("/api", "") || "http://localhost:8080"
 
 export const materialsApi = {
-    getAll: async (): Promise<Material[]> => {
-        const res = await apiClient.get("/materials/")
-        return res.data
-    },
+    getAll: (): Promise<Material[]> => getAllPages<Material>("/materials/", { limit: 200 }),
     uploadImage: async (data: FormData): Promise<Material> => {
         const res = await apiClient.post("/materials/upload", data, {
             headers: { "Content-Type": "multipart/form-data" },
@@ -1,4 +1,5 @@
 import Image from "next/image";
+import { Suspense } from "react";
 import { LoginForm } from "@/components/login-form";
 
 export const metadata = {
@@ -14,7 +15,10 @@ export default function Page() {
       <div className="w-full max-w-md p-6 relative z-20">
         <div className="bg-white/20 backdrop-blur-xl rounded-2xl border border-white/30 p-8 relative overflow-hidden">
           <div className="relative z-10">
-            <LoginForm />
+            {/* LoginForm reads the query set by the OIDC callback */}
+            <Suspense>
+              <LoginForm />
+            </Suspense>
           </div>
         </div>
       </div>
@@ -3,41 +3,121 @@ import { cn } from "@/lib/utils";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
-import { useLogin } from "@/hooks/auth";
-import { useRouter } from "next/navigation";
+import { useLogin, useLoginTwoFactor } from "@/hooks/auth";
+import { useRouter, useSearchParams } from "next/navigation";
 import toast from "react-hot-toast";
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { AlertCircle } from "lucide-react";
 import clsx from "clsx";
 import { isAxiosError } from "axios";
 
 const CLASS_EMAIL = "vilnevtsits.martin@tlvl.ee";
 
+// Errors passed by the OIDC callback in the error query parameter
+const OIDC_ERRORS: Record<string, string> = {
+  oidc: "Sisselogimine teenusepakkuja kaudu ebaõnnestus, proovi uuesti.",
+  oidc_state: "Sisselogimine aegus või alustati teises brauseris, proovi uuesti.",
+  oidc_email: "Teenusepakkuja ei ole sinu e-posti aadressi kinnitanud.",
+};
+
 export function LoginForm({
   className,
   ...props
 }: React.ComponentProps<"form">) {
   const [password, setPassword] = useState("");
+  const [code, setCode] = useState("");
+  // Set when the password was accepted and a two-factor code is required. After an OIDC login
+  // it is empty, the API keeps the challenge in a cookie
+  const [challenge, setChallenge] = useState<string | null>(null);
   const [error, setError] = useState<string | null>(null);
-  const { mutateAsync: loginUser, isPending } = useLogin();
+  const { mutateAsync: loginUser, isPending: isLoginPending } = useLogin();
+  const { mutateAsync: loginTwoFactor, isPending: isTwoFactorPending } =
+    useLoginTwoFactor();
+  const isPending = isLoginPending || isTwoFactorPending;
   const router = useRouter();
+  const searchParams = useSearchParams();
+
+  // The OIDC callback redirects here with two_factor=1 or an error, the parameters are read once
+  // and removed so that reloading doesn't show them again
+  useEffect(() => {
+    const oidcError = searchParams.get("error");
+    const twoFactor = searchParams.get("two_factor");
+    if (!oidcError && !twoFactor) {
+      return;
+    }
+
+    if (twoFactor) {
+      setChallenge("");
+    }
+    if (oidcError) {
+      setError(OIDC_ERRORS[oidcError] ?? OIDC_ERRORS.oidc);
+    }
+
+    router.replace("/auth/sign-in");
+  }, [searchParams, router]);
 
   const handleSubmit = async (e: React.FormEvent<HTMLFormElement>) => {
     e.preventDefault();
     setError(null);
 
+    if (challenge !== null) {
+      await submitCode();
+      return;
+    }
+
     if (!password || password.trim().length === 0) {
       setError("Palun sisesta parool.");
       return;
     }
 
     try {
-      await loginUser({ email: CLASS_EMAIL, password });
+      const response = await loginUser({ email: CLASS_EMAIL, password });
+      if (response?.two_factor_required) {
+        setChallenge(response.challenge);
+        return;
+      }
       router.push("/home");
     } catch (err: unknown) {
       if (isAxiosError(err)) {
         if (err.response?.status === 401 || err.response?.status === 403) {
           setError("Vale parool.");
+        } else if (err.response?.status === 429) {
+          setError("Liiga palju katseid, proovi hiljem uuesti.");
+        } else if (err.response?.status === 500) {
+          setError("Serveri viga, proovi hiljem uuesti.");
+        } else {
+          setError("Midagi läks valesti, proovi uuesti.");
+        }
+      } else {
+        setError("Midagi läks valesti, proovi uuesti.");
+      }
+    }
+  };
+
+  const submitCode = async () => {
+    if (challenge === null) {
+      return;
+    }
+
+    if (!code || code.trim().length === 0) {
+      setError("Palun sisesta kood.");
+      return;
+    }
+
+    try {
+      await loginTwoFactor({ challenge, code: code.trim() });
+      router.push("/home");
+    } catch (err: unknown) {
+      if (isAxiosError(err)) {
+        if (err.response?.data?.code === "challenge_not_found") {
+          // The challenge expired or too many wrong codes were entered
+          setChallenge(null);
+          setCode("");
+          setError("Kinnitus aegus, logi uuesti sisse.");
+        } else if (err.response?.status === 401) {
+          setError("Vale kood.");
+        } else if (err.response?.status === 429) {
+          setError("Liiga palju katseid, proovi hiljem uuesti.");
         } else if (err.response?.status === 500) {
           setError("Serveri viga, proovi hiljem uuesti.");
         } else {
@@ -61,25 +141,50 @@ export function LoginForm({
         </span>
       </div>
       <div className="grid gap-6">
-        <div className="grid gap-3">
-          <Label htmlFor="password" className="text-gray-700 font-medium text-sm">
-            Sisesta klassi parool
-          </Label>
-          <Input
-            id="password"
-            type="password"
-            placeholder="Sisesta parool..."
-            required
-            value={password}
-            onChange={(e) => setPassword(e.target.value)}
-            className={clsx(
-              "h-11 px-3 text-sm rounded-md border transition-colors duration-200",
-              "border-gray-200 hover:border-gray-300 focus:border-[#1e3a5f] focus:ring-0 focus:outline-none",
-              "bg-white",
-              { "border-[#1e3a5f] focus:border-[#1e3a5f]": error },
-            )}
-          />
-        </div>
+        {challenge !== null ? (
+          <div className="grid gap-3">
+            <Label htmlFor="code" className="text-gray-700 font-medium text-sm">
+              Sisesta autentimisrakenduse kood
+            </Label>
+            <Input
+              id="code"
+              type="text"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              placeholder="123456"
+              required
+              autoFocus
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              className={clsx(
+                "h-11 px-3 text-sm rounded-md border transition-colors duration-200",
+                "border-gray-200 hover:border-gray-300 focus:border-[#1e3a5f] focus:ring-0 focus:outline-none",
+                "bg-white",
+                { "border-[#1e3a5f] focus:border-[#1e3a5f]": error },
+              )}
+            />
+          </div>
+        ) : (
+          <div className="grid gap-3">
+            <Label htmlFor="password" className="text-gray-700 font-medium text-sm">
+              Sisesta klassi parool
+            </Label>
+            <Input
+              id="password"
+              type="password"
+              placeholder="Sisesta parool..."
+              required
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className={clsx(
+                "h-11 px-3 text-sm rounded-md border transition-colors duration-200",
+                "border-gray-200 hover:border-gray-300 focus:border-[#1e3a5f] focus:ring-0 focus:outline-none",
+                "bg-white",
+                { "border-[#1e3a5f] focus:border-[#1e3a5f]": error },
+              )}
+            />
+          </div>
+        )}
         {error && (
           <div className="flex items-center gap-2 rounded-md bg-blue-50 border border-blue-200 p-3">
             <AlertCircle className="text-[#1e3a5f] flex-shrink-0" size={16} />
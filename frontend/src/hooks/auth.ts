@@ -23,6 +23,25 @@ export function useLogin() {
     });
 }
 
+export function useLoginTwoFactor() {
+    const queryClient = useQueryClient();
+    return useMutation({
+        mutationFn: async ({
+            challenge,
+            code,
+        }: {
+            challenge: string;
+            code: string;
+        }) => {
+            const response = await authApi.loginTwoFactor(challenge, code);
+            return response;
+        },
+        onSuccess: () => {
+            queryClient.invalidateQueries({ queryKey: authKeys.currentUser() });
+        },
+    });
+}
+
 export function useSignup() {
     const queryClient = useQueryClient();
     return useMutation({
@@ -11,6 +11,70 @@ export const apiClient = axios.create({
     withCredentials: true,
 })
 
+type PageResponse<T> = {
+    items: T[]
+    next_cursor: string
+}
+
+// Fetches every page of a paginated list by following next_cursor until the last page
+export async function getAllPages<T>(url: string, params: Record<string, unknown> = {}): Promise<T[]> {
+    const items: T[] = []
+    let cursor = ""
+
+    do {
+        const res = await apiClient.get<PageResponse<T>>(url, {
+            params: cursor ? { ...params, cursor } : params,
+        })
+        items.push(...res.data.items)
+        cursor = res.data.next_cursor
+    } while (cursor)
+
+    return items
+}
+
+const CSRF_HEADER = "X-CSRF-Token"
+const SAFE_METHODS = ["get", "head", "options"]
+
+// Token bound to the current session, required by the API for state-changing requests
+let csrfToken: string | null = null
+
+export async function fetchCsrfToken(): Promise<string> {
+    const res = await axios.get(`${API_URL}/me/csrf`, { withCredentials: true })
+    csrfToken = res.data.token as string
+    return csrfToken
+}
+
+// Has to be called when the session changes, the token is bound to the session
+export function clearCsrfToken() {
+    csrfToken = null
+}
+
+function needsCsrfToken(method?: string, url?: string) {
+    if (SAFE_METHODS.includes((method || "get").toLowerCase())) {
+        return false
+    }
+    // Login and registration happen before there is a session
+    return !url?.startsWith("/users/")
+}
+
+apiClient.interceptors.request.use(async (config) => {
+    if (!needsCsrfToken(config.method, config.url)) {
+        return config
+    }
+
+    if (!csrfToken) {
+        try {
+            await fetchCsrfToken()
+        } catch {
+            // Without a session the request fails with 401 anyway
+            return config
+        }
+    }
+
+    config.headers.set(CSRF_HEADER, csrfToken)
+    return config
+})
+
 apiClient.interceptors.response.use(
     (response) => {
         if (useServerErrorStore.getState().isServerDown) {
@@ -18,8 +82,25 @@ apiClient.interceptors.response.use(
         }
         return response
     },
-    (error) => {
-        const { response } = error
+    async (error) => {
+        const { response, config } = error
+
+        // The session changed since the token was fetched, retry once with a new token
+        if (
+            response?.status === 403 &&
+            response.data?.code === "invalid_csrf_token" &&
+            config &&
+            !config._csrfRetried
+        ) {
+            config._csrfRetried = true
+            clearCsrfToken()
+            try {
+                await fetchCsrfToken()
+                return await apiClient(config)
+            } catch (retryError) {
+                return Promise.reject(retryError)
+            }
+        }
 
         if (response && response.status === 401) {
             console.error(